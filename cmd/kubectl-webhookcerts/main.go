@@ -0,0 +1,241 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// kubectl-webhookcerts is a kubectl plugin listing the webhooks managed by
+// kube-admission-webhook with their secrets, expirations and verification
+// status, with --force-rotate it asks the running Manager to rotate them.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate"
+)
+
+type webhook struct {
+	name        string
+	webhookType certificate.WebhookType
+}
+
+// caOptions are the Manager options placing the CA secret, they have to
+// match the ones from the running Manager to inspect it.
+type caOptions struct {
+	caNamespace    string
+	sharedCASecret string
+}
+
+func main() {
+	namespace := flag.String("namespace", "", "namespace of the CA secret and the URL webhooks secrets")
+	webhookName := flag.String("webhook", "", "webhook configuration name, if not set the ones owning secrets at --namespace are listed")
+	webhookType := flag.String("type", string(certificate.MutatingWebhook), "webhook configuration type, Mutating or Validating")
+	forceRotate := flag.Bool("force-rotate", false, "ask the Manager to rotate the CA and services certificates")
+	reason := flag.String("reason", "forced rotation requested by kubectl-webhookcerts", "reason audited for --force-rotate")
+	ca := caOptions{}
+	flag.StringVar(&ca.caNamespace, "ca-namespace", "", "namespace of the CA secret if the Manager sets CANamespace")
+	flag.StringVar(&ca.sharedCASecret, "shared-ca-secret", "", "<namespace>/<name> of the CA secret if the Manager sets SharedCASecret")
+	flag.Parse()
+
+	err := run(*namespace, *webhookName, certificate.WebhookType(*webhookType), ca, *forceRotate, *reason)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(namespace, webhookName string, webhookType certificate.WebhookType, ca caOptions, forceRotate bool, reason string) error {
+	if namespace == "" {
+		return errors.New("--namespace is mandatory")
+	}
+	sharedCASecret, err := ca.sharedCASecretKey()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return errors.Wrap(err, "failed getting kubeconfig")
+	}
+	scheme := runtime.NewScheme()
+	err = clientgoscheme.AddToScheme(scheme)
+	if err != nil {
+		return errors.Wrap(err, "failed adding client-go scheme")
+	}
+	cli, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return errors.Wrap(err, "failed creating client")
+	}
+
+	ctx := context.Background()
+	webhooks := []webhook{{name: webhookName, webhookType: webhookType}}
+	if webhookName == "" {
+		webhooks, err = managedWebhooks(ctx, cli, namespace)
+		if err != nil {
+			return err
+		}
+		if len(webhooks) == 0 {
+			return errors.Errorf("no secrets managed by kube-admission-webhook found at namespace %s, use --webhook", namespace)
+		}
+	}
+
+	if forceRotate {
+		for _, w := range webhooks {
+			err = requestRotation(ctx, cli, w, reason)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s webhook %s rotation requested\n", w.webhookType, w.name)
+		}
+		return nil
+	}
+	return printStatus(ctx, cli, namespace, ca.caNamespace, sharedCASecret, webhooks)
+}
+
+func (o caOptions) sharedCASecretKey() (*types.NamespacedName, error) {
+	if o.sharedCASecret == "" {
+		return nil, nil
+	}
+	namespace, name, found := strings.Cut(o.sharedCASecret, "/")
+	if !found || namespace == "" || name == "" {
+		return nil, errors.Errorf("--shared-ca-secret %q has to be <namespace>/<name>", o.sharedCASecret)
+	}
+	return &types.NamespacedName{Namespace: namespace, Name: name}, nil
+}
+
+// managedWebhooks returns the webhook configurations owning the secrets
+// at namespace, the Managers annotate every secret they write with its
+// owner so this works without Options.LabelWebhookConfiguration, secrets
+// written by older versions have no owner and are skipped.
+func managedWebhooks(ctx context.Context, cli client.Client, namespace string) ([]webhook, error) {
+	secrets := corev1.SecretList{}
+	err := cli.List(ctx, &secrets, client.InNamespace(namespace))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed listing secrets at namespace %s", namespace)
+	}
+
+	webhooks := []webhook{}
+	found := map[webhook]bool{}
+	for i := range secrets.Items {
+		webhookName, webhookType, owned := certificate.SecretOwner(&secrets.Items[i])
+		if !owned {
+			continue
+		}
+		w := webhook{name: webhookName, webhookType: webhookType}
+		if found[w] {
+			continue
+		}
+		found[w] = true
+		webhooks = append(webhooks, w)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		if webhooks[i].webhookType != webhooks[j].webhookType {
+			return webhooks[i].webhookType < webhooks[j].webhookType
+		}
+		return webhooks[i].name < webhooks[j].name
+	})
+	return webhooks, nil
+}
+
+// requestRotation annotates the webhook configuration with
+// certificate.ForceRotationAnnotationKey so the Manager rotates it, this
+// way the rotation is done by the running Manager, only the one holding the
+// CA rotation Lease with LockCARotation or SharedCASecret.
+func requestRotation(ctx context.Context, cli client.Client, w webhook, reason string) error {
+	var configuration client.Object = &admissionregistrationv1.MutatingWebhookConfiguration{}
+	if w.webhookType == certificate.ValidatingWebhook {
+		configuration = &admissionregistrationv1.ValidatingWebhookConfiguration{}
+	}
+	err := cli.Get(ctx, client.ObjectKey{Name: w.name}, configuration)
+	if err != nil {
+		return errors.Wrapf(err, "failed getting %s webhook %s", w.webhookType, w.name)
+	}
+
+	patch := client.MergeFrom(configuration.DeepCopyObject().(client.Object))
+	annotations := configuration.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[certificate.ForceRotationAnnotationKey] = reason
+	configuration.SetAnnotations(annotations)
+	err = cli.Patch(ctx, configuration, patch)
+	if err != nil {
+		return errors.Wrapf(err, "failed requesting rotation of %s webhook %s", w.webhookType, w.name)
+	}
+	return nil
+}
+
+// printStatus prints a row per secret with the expiration of its last
+// certificate and the webhook verification status.
+func printStatus(ctx context.Context, cli client.Client, namespace, caNamespace string,
+	sharedCASecret *types.NamespacedName, webhooks []webhook) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "WEBHOOK\tTYPE\tSECRET\tNOT AFTER\tVERIFIED")
+	for _, w := range webhooks {
+		manager, err := certificate.NewManager(cli, &certificate.Options{
+			WebhookName:    w.name,
+			WebhookType:    w.webhookType,
+			Namespace:      namespace,
+			CANamespace:    caNamespace,
+			SharedCASecret: sharedCASecret,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed creating manager for %s webhook %s", w.webhookType, w.name)
+		}
+
+		inspection, err := manager.InspectChain(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "failed inspecting %s webhook %s", w.webhookType, w.name)
+		}
+
+		verified := "True"
+		err = manager.Verify(ctx)
+		if err != nil {
+			verified = fmt.Sprintf("False: %v", err)
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", w.name, w.webhookType, inspection.CASecret,
+			notAfter(inspection.CABundle), verified)
+		for _, service := range inspection.Services {
+			fmt.Fprintf(writer, "%s\t%s\t%s/%s\t%s\t%s\n", w.name, w.webhookType, service.Namespace, service.Name,
+				notAfter(service.Certificates), verified)
+		}
+	}
+	return writer.Flush()
+}
+
+// notAfter returns the expiration of the last prepended certificate
+func notAfter(certs []certificate.CertificateInfo) string {
+	if len(certs) == 0 {
+		return "<none>"
+	}
+	return certs[0].NotAfter.Format(time.RFC3339)
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// AcknowledgementAnnotationPrefix prefixes the TLS secret annotations
+	// where every webhook replica acknowledges the certificate it serves,
+	// the annotation name is the replica and the value the certificate
+	// fingerprint
+	AcknowledgementAnnotationPrefix = "ack.kube-admission-webhook.kubevirt.io/"
+
+	acknowledgementPollInterval = 10 * time.Second
+)
+
+// AcknowledgeCertificate is called by the webhook replicas after they
+// load a new serving certificate from the TLS secret, it annotates the
+// secret so the Manager with Options.CleanupAcknowledgements only removes
+// the previous CAs and certificates once all the replicas serve the new
+// ones. replica has to be unique and a valid annotation name, like the pod
+// name.
+func AcknowledgeCertificate(ctx context.Context, cli client.Client, secretKey types.NamespacedName, replica string,
+	cert *x509.Certificate) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret := corev1.Secret{}
+		err := cli.Get(ctx, secretKey, &secret)
+		if err != nil {
+			return errors.Wrapf(err, "failed reading TLS secret %s to acknowledge certificate", secretKey)
+		}
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[AcknowledgementAnnotationPrefix+replica] = CertificateFingerprint(cert)
+		return cli.Update(ctx, &secret)
+	})
+}
+
+// cleanupAcknowledged returns true if at least
+// Options.CleanupAcknowledgements replicas acknowledge the newest
+// certificate of every TLS secret.
+func (m *Manager) cleanupAcknowledged(ctx context.Context) (bool, error) {
+	if m.cleanupAcknowledgements == 0 {
+		return true, nil
+	}
+
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "failed reading webhook configuration")
+	}
+
+	for _, clientConfig := range m.clientConfigList(webhookConf) {
+		secretKey := m.secretKeyForClientConfig(clientConfig)
+		secret := corev1.Secret{}
+		err = m.get(ctx, secretKey, &secret)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed reading TLS secret %s", secretKey)
+		}
+		var certs []*x509.Certificate
+		certs, err = m.getTLSCerts(ctx, secretKey)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed reading TLS secret %s certificates", secretKey)
+		}
+		newestCert := getFirstCert(certs)
+		if newestCert == nil {
+			return false, nil
+		}
+		acknowledgements := countAcknowledgements(secret.Annotations, CertificateFingerprint(newestCert))
+		if acknowledgements < m.cleanupAcknowledgements {
+			m.log.Info("Waiting for certificate acknowledgements before cleanup", "secret", secretKey,
+				"acknowledgements", acknowledgements, "required", m.cleanupAcknowledgements)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func countAcknowledgements(annotations map[string]string, fingerprint string) int {
+	acknowledgements := 0
+	for key, value := range annotations {
+		if strings.HasPrefix(key, AcknowledgementAnnotationPrefix) && value == fingerprint {
+			acknowledgements++
+		}
+	}
+	return acknowledgements
+}
+
+// postponeCleanup re-checks the acknowledgements or the served
+// certificates later if the cleanup deadline has passed, the
+// acknowledgements also trigger a Reconcile since they update the TLS
+// secrets.
+func postponeCleanup(elapsedForCleanup time.Duration) time.Duration {
+	if elapsedForCleanup <= 0 {
+		return acknowledgementPollInterval
+	}
+	return elapsedForCleanup
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// adoptSecrets annotates as managed the services TLS secrets not created by
+// the Manager that verify against the CA secret and the webhook CABundle,
+// the secrets failing verification are left alone so the usual rotation
+// overwrites them. With Options.AdoptCASecret the CA that issued them is
+// imported first so they are kept until their rotation deadline.
+func (m *Manager) adoptSecrets(ctx context.Context) error {
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed reading webhook configuration to adopt secrets")
+	}
+
+	if m.adoptCASecret != nil {
+		err = m.importAdoptedCA(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed importing CA of the secrets to adopt")
+		}
+	}
+
+	caKeyPair, err := m.getCAKeyPair(ctx)
+	if err != nil {
+		// Without CA there is nothing to verify the secrets against
+		m.log.Info(fmt.Sprintf("Not adopting secrets, failed getting CA key pair: %v", err))
+		return nil
+	}
+
+	for _, clientConfig := range m.clientConfigList(webhookConf) {
+		secretKey := m.secretKeyForClientConfig(clientConfig)
+		secret := corev1.Secret{}
+		err = m.getUnlabeled(ctx, secretKey, &secret)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return errors.Wrapf(err, "failed reading TLS secret %s to adopt it", secretKey)
+		}
+
+		if isAnnotatedResource(&secret) {
+			continue
+		}
+
+		var caBundle []byte
+		caBundle, err = m.clientConfigCABundle(ctx, clientConfig)
+		if err != nil {
+			return errors.Wrap(err, "failed getting CABundle to adopt TLS secrets")
+		}
+		err = m.verifyTLSSecretData(secretKey, &secret, caKeyPair, caBundle)
+		if err != nil {
+			m.log.Info(fmt.Sprintf("Not adopting TLS secret %s, failed verification: %v", secretKey, err))
+			continue
+		}
+
+		m.setExtraMetadata(&secret)
+		m.setSecretOwner(&secret)
+		err = m.storage.Update(ctx, &secret)
+		if err != nil {
+			return errors.Wrapf(err, "failed annotating adopted TLS secret %s", secretKey)
+		}
+		m.log.Info("Adopted TLS secret", "secret", secretKey)
+		m.audit(ctx, AuditActionAdopt, "existing TLS secret verified against CA and CABundle", fmt.Sprintf("Secret/%s", secretKey))
+
+		// Re-calculate services deadline from the adopted certificate
+		m.lastRotateDeadlineForServices = nil
+	}
+	return nil
+}
+
+// importAdoptedCA stores the Options.AdoptCASecret CA at the CA secret and
+// the CABundle if the CA secret does not exist yet, an existing CA secret
+// is never overwritten.
+func (m *Manager) importAdoptedCA(ctx context.Context) error {
+	err := m.getUnlabeled(ctx, m.caSecretKey(), &corev1.Secret{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed reading CA secret %s", m.caSecretKey())
+	}
+
+	adoptedCASecret := corev1.Secret{}
+	err = m.getUnlabeled(ctx, *m.adoptCASecret, &adoptedCASecret)
+	if err != nil {
+		return errors.Wrapf(err, "failed reading CA secret %s to adopt", *m.adoptCASecret)
+	}
+	caKeyPair, err := parseArchiveKeyPair(string(adoptedCASecret.Data[corev1.TLSCertKey]),
+		string(adoptedCASecret.Data[corev1.TLSPrivateKeyKey]))
+	if err != nil {
+		return errors.Wrapf(err, "failed parsing CA key pair at secret %s", *m.adoptCASecret)
+	}
+
+	err = m.applyCASecret(ctx, caKeyPair)
+	if err != nil {
+		return errors.Wrap(err, "failed storing adopted CA at CA secret")
+	}
+	err = m.addCertificateToCABundle(ctx, caKeyPair.Cert)
+	if err != nil {
+		return errors.Wrap(err, "failed adding adopted CA to CABundle")
+	}
+	err = m.applyCATrustStore(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed storing CA trust store for adopted CA")
+	}
+
+	// Re-calculate CA deadline from the adopted CA
+	m.lastRotateDeadline = nil
+	m.log.Info("Imported CA to adopt TLS secrets", "secret", *m.adoptCASecret)
+	m.audit(ctx, AuditActionImport, "CA of the existing TLS secrets imported", fmt.Sprintf("Secret/%s", m.caSecretKey()))
+	return nil
+}
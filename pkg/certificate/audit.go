@@ -0,0 +1,175 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	AuditActionRotateAll           = "RotateAll"
+	AuditActionRotateServices      = "RotateServices"
+	AuditActionCleanUpCABundle     = "CleanUpCABundle"
+	AuditActionCleanUpServiceCerts = "CleanUpServiceCerts"
+	AuditActionRollback            = "Rollback"
+	AuditActionAdopt               = "Adopt"
+	AuditActionImport              = "Import"
+
+	// AuditLogKey is the ConfigMapAuditRecorder key containing the events
+	// as JSON lines
+	AuditLogKey = "audit.log"
+)
+
+// AuditEvent describes an operation done by the Manager on the certificate
+// chain
+type AuditEvent struct {
+	// Time is when the operation finished
+	Time time.Time `json:"time"`
+
+	// Actor identifies the Manager, it's "${WebhookType}/${WebhookName}"
+	Actor string `json:"actor"`
+
+	// Action is one of the AuditAction constants
+	Action string `json:"action"`
+
+	// Reason explains why the operation was done
+	Reason string `json:"reason"`
+
+	// Objects are the objects written by the operation
+	Objects []string `json:"objects"`
+}
+
+// AuditRecorder receives an AuditEvent for every rotation, cleanup, rollback
+// adoption and import done by the Manager. Record errors are logged but do
+// not fail the operations so an audit sink outage does not block the
+// rotations.
+type AuditRecorder interface {
+	Record(ctx context.Context, event AuditEvent) error
+}
+
+// ConfigMapAuditRecorder appends the AuditEvents as JSON lines at the
+// AuditLogKey of a ConfigMap
+type ConfigMapAuditRecorder struct {
+	client    crclient.Client
+	key       types.NamespacedName
+	maxEvents int
+}
+
+// NewConfigMapAuditRecorder returns an AuditRecorder that appends the events
+// to the ConfigMap at key, keeping the last maxEvents of them so it does not
+// reach the ConfigMap size limit, if maxEvents is zero all are kept.
+// The ConfigMap is labeled with ManagedLabelKey so it's visible with
+// NewCache.
+func NewConfigMapAuditRecorder(client crclient.Client, key types.NamespacedName, maxEvents int) *ConfigMapAuditRecorder {
+	return &ConfigMapAuditRecorder{client: client, key: key, maxEvents: maxEvents}
+}
+
+func (r *ConfigMapAuditRecorder) Record(ctx context.Context, event AuditEvent) error {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "failed encoding audit event")
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap := &corev1.ConfigMap{}
+		err := r.client.Get(ctx, r.key, configMap)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return errors.Wrapf(err, "failed reading audit configmap %s", r.key)
+			}
+			configMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      r.key.Name,
+					Namespace: r.key.Namespace,
+					Labels:    map[string]string{ManagedLabelKey: ""},
+				},
+				Data: map[string]string{AuditLogKey: string(eventJSON) + "\n"},
+			}
+			return r.client.Create(ctx, configMap)
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		events := strings.SplitAfter(configMap.Data[AuditLogKey], "\n")
+		// SplitAfter returns an empty last element since the log ends
+		// with a new line
+		events = append(events[:len(events)-1], string(eventJSON)+"\n")
+		if r.maxEvents > 0 && len(events) > r.maxEvents {
+			events = events[len(events)-r.maxEvents:]
+		}
+		configMap.Data[AuditLogKey] = strings.Join(events, "")
+		return r.client.Update(ctx, configMap)
+	})
+}
+
+// audit records an AuditEvent if Options.AuditRecorder is set
+func (m *Manager) audit(ctx context.Context, action, reason string, objects ...string) {
+	if m.auditRecorder == nil {
+		return
+	}
+	err := m.auditRecorder.Record(ctx, AuditEvent{
+		Time:    m.now().UTC(),
+		Actor:   m.secretOwner(),
+		Action:  action,
+		Reason:  reason,
+		Objects: objects,
+	})
+	if err != nil {
+		m.log.Info(fmt.Sprintf("Failed recording audit event %s: %v", action, err))
+	}
+}
+
+// chainObjects returns the webhook configuration, CA secret and services
+// secrets references, it's best effort since it's only used to describe
+// the audit events.
+func (m *Manager) chainObjects(ctx context.Context) []string {
+	objects := []string{
+		fmt.Sprintf("%sWebhookConfiguration/%s", m.webhookType, m.webhookName),
+		fmt.Sprintf("Secret/%s", m.caSecretKey()),
+	}
+	if m.caCertInConfigMap {
+		objects = append(objects, fmt.Sprintf("ConfigMap/%s", m.caConfigMapKey()))
+	}
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
+	if err != nil {
+		return objects
+	}
+	services, err := m.getServicesFromConfiguration(webhookConf)
+	if err != nil {
+		return objects
+	}
+	serviceSecrets := []string{}
+	for service := range services {
+		serviceSecrets = append(serviceSecrets, fmt.Sprintf("Secret/%s", service))
+	}
+	sort.Strings(serviceSecrets)
+	return append(objects, serviceSecrets...)
+}
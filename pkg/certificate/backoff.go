@@ -0,0 +1,83 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	verificationBackoffBase = 5 * time.Second
+	verificationBackoffMax  = 5 * time.Minute
+)
+
+// VerificationError is returned when the certificate chain keeps failing
+// verification after rotating it, Reconcile retries it after RetryAfter
+// instead of rotating again on every event, doubling it at every failure.
+type VerificationError struct {
+	// Err is the verification failure
+	Err error
+
+	// Failures is the number of consecutive verification failures
+	Failures int
+
+	// RetryAfter is the time left to verify and rotate again
+	RetryAfter time.Duration
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("TLS certificate chain failed verification %d consecutive times, retrying in %s: %v",
+		e.Failures, e.RetryAfter, e.Err)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+// verificationBackoff doubles verificationBackoffBase for every
+// consecutive failure up to verificationBackoffMax
+func verificationBackoff(failures int) time.Duration {
+	backoff := verificationBackoffBase
+	for i := 1; i < failures && backoff < verificationBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > verificationBackoffMax {
+		return verificationBackoffMax
+	}
+	return backoff
+}
+
+// recordVerification counts the consecutive verification failures, the
+// first one forces a rotation right away, the next ones return a
+// VerificationError until the backoff has passed.
+func (m *Manager) recordVerification(err error) error {
+	if err == nil {
+		m.verificationFailures = 0
+		verificationFailureCount.WithLabelValues(m.webhookName, string(m.webhookType)).Set(0)
+		return nil
+	}
+
+	m.verificationFailures++
+	verificationFailureCount.WithLabelValues(m.webhookName, string(m.webhookType)).Set(float64(m.verificationFailures))
+	now := m.now()
+	if m.verificationFailures > 1 && now.Before(m.verificationRetryAt) {
+		return &VerificationError{Err: err, Failures: m.verificationFailures, RetryAfter: m.verificationRetryAt.Sub(now)}
+	}
+	m.verificationRetryAt = now.Add(verificationBackoff(m.verificationFailures))
+	return nil
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate"
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate/testutil"
+)
+
+const benchmarkWebhookName = "benchmark"
+
+var benchmarkServicesCount = []int{1, 10, 100}
+
+// benchmarkObjects returns a webhook configuration with a webhook per
+// service so the Manager issues servicesCount certificates
+func benchmarkObjects(servicesCount int) []client.Object {
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	webhookConfiguration := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: benchmarkWebhookName},
+	}
+	objects := []client.Object{webhookConfiguration}
+	for i := 0; i < servicesCount; i++ {
+		service := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: benchmarkWebhookName, Name: fmt.Sprintf("service-%d", i)},
+		}
+		objects = append(objects, service)
+		webhookConfiguration.Webhooks = append(webhookConfiguration.Webhooks, admissionregistrationv1.MutatingWebhook{
+			Name:                    fmt.Sprintf("webhook-%d.qinqon.io", i),
+			SideEffects:             &sideEffects,
+			AdmissionReviewVersions: []string{"v1"},
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service: &admissionregistrationv1.ServiceReference{Namespace: service.Namespace, Name: service.Name},
+			},
+		})
+	}
+	return objects
+}
+
+func newBenchmarkManager(b *testing.B, servicesCount int) *certificate.Manager {
+	manager, _, err := testutil.NewManager(&certificate.Options{
+		WebhookName: benchmarkWebhookName,
+		WebhookType: certificate.MutatingWebhook,
+		Namespace:   benchmarkWebhookName,
+	}, benchmarkObjects(servicesCount)...)
+	if err != nil {
+		b.Fatalf("failed creating certificate manager: %v", err)
+	}
+	return manager
+}
+
+var benchmarkRequest = reconcile.Request{NamespacedName: types.NamespacedName{Name: benchmarkWebhookName}}
+
+// BenchmarkReconcileIssue measures the first Reconcile, issuing the CA and
+// the services certificates
+func BenchmarkReconcileIssue(b *testing.B) {
+	for _, servicesCount := range benchmarkServicesCount {
+		b.Run(fmt.Sprintf("services=%d", servicesCount), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				manager := newBenchmarkManager(b, servicesCount)
+				b.StartTimer()
+				_, err := manager.Reconcile(context.Background(), benchmarkRequest)
+				if err != nil {
+					b.Fatalf("failed reconciling: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkReconcileUpToDate measures a Reconcile with nothing to rotate,
+// the path taken by every watched event
+func BenchmarkReconcileUpToDate(b *testing.B) {
+	for _, servicesCount := range benchmarkServicesCount {
+		b.Run(fmt.Sprintf("services=%d", servicesCount), func(b *testing.B) {
+			manager := newBenchmarkManager(b, servicesCount)
+			_, err := manager.Reconcile(context.Background(), benchmarkRequest)
+			if err != nil {
+				b.Fatalf("failed reconciling: %v", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err = manager.Reconcile(context.Background(), benchmarkRequest)
+				if err != nil {
+					b.Fatalf("failed reconciling: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkReadyzCheck measures the verification of the certificate chain
+func BenchmarkReadyzCheck(b *testing.B) {
+	for _, servicesCount := range benchmarkServicesCount {
+		b.Run(fmt.Sprintf("services=%d", servicesCount), func(b *testing.B) {
+			manager := newBenchmarkManager(b, servicesCount)
+			_, err := manager.Reconcile(context.Background(), benchmarkRequest)
+			if err != nil {
+				b.Fatalf("failed reconciling: %v", err)
+			}
+			request := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err = manager.ReadyzCheck(request)
+				if err != nil {
+					b.Fatalf("failed verifying certificates: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate/triple"
+)
+
+func (m *Manager) isCABundleSource(object client.Object) bool {
+	return m.caBundleSource != nil && object.GetNamespace() == m.caBundleSource.Namespace &&
+		object.GetName() == m.caBundleSource.Name
+}
+
+// reconcileCABundleSource keeps the webhook configuration CABundle in sync
+// with the CA certificates at Options.CABundleSource and verifies the
+// services TLS secrets against it, the secrets are issued externally so
+// they are never written.
+func (m *Manager) reconcileCABundleSource(ctx context.Context) (reconcile.Result, error) {
+	err := m.labelManagedObjectsOnce(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	sourceCAs, err := m.getCABundleSourceCerts(ctx)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "failed getting CA certificates from CABundle source")
+	}
+
+	err = m.updateWebhookCABundleWithFunc(ctx, func(currentCABundle []byte) ([]byte, error) {
+		return m.mergeCABundle(currentCABundle, sourceCAs)
+	})
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "failed updating CABundle from CABundle source")
+	}
+
+	err = m.verifyServicesTLSSecrets(ctx)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "failed verifying services TLS secrets against CABundle")
+	}
+
+	// Requeue at the next CA expiration to remove it from the CABundle
+	cas, err := m.getCACertsFromCABundle(ctx)
+	if err != nil {
+		return reconcile.Result{}, errors.Wrap(err, "failed getting CA certificates from CA bundle")
+	}
+	if len(cas) == 0 {
+		return reconcile.Result{}, nil
+	}
+	return reconcile.Result{RequeueAfter: m.earliestCleanupDeadlineForCerts(cas).Sub(m.now())}, nil
+}
+
+func (m *Manager) getCABundleSourceCerts(ctx context.Context) ([]*x509.Certificate, error) {
+	sourceSecret := corev1.Secret{}
+	err := m.getUnlabeled(ctx, *m.caBundleSource, &sourceSecret)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading CABundle source secret %s", *m.caBundleSource)
+	}
+	caPEM, found := sourceSecret.Data[CACertKey]
+	if !found {
+		return nil, errors.Errorf("CA cert not found at CABundle source secret %s", *m.caBundleSource)
+	}
+	cas, err := triple.ParseCertsPEM(caPEM)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed parsing CA cert PEM at CABundle source secret %s", *m.caBundleSource)
+	}
+	return cas, nil
+}
+
+// mergeCABundle prepends the source CAs to caBundle keeping the previous
+// CAs until they expire, so the webhooks still serving certificates
+// signed by them keep working.
+func (m *Manager) mergeCABundle(caBundle []byte, sourceCAs []*x509.Certificate) ([]byte, error) {
+	previousCAs := []*x509.Certificate{}
+	if len(caBundle) > 0 {
+		var err error
+		previousCAs, err = triple.ParseCertsPEM(caBundle)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed parsing current CA bundle")
+		}
+	}
+	now := m.now()
+	merged := append([]*x509.Certificate{}, sourceCAs...)
+	for _, previousCA := range previousCAs {
+		if !now.Before(previousCA.NotAfter) || containsCert(sourceCAs, previousCA) {
+			continue
+		}
+		merged = append(merged, previousCA)
+	}
+	return triple.EncodeCertsPEM(merged), nil
+}
+
+// verifyServicesTLSSecrets verifies the TLS secrets of all the services
+// against the CABundle without checking the CA secret.
+func (m *Manager) verifyServicesTLSSecrets(ctx context.Context) error {
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to reading configuration")
+	}
+
+	for _, clientConfig := range m.clientConfigList(webhookConf) {
+		secretKey := m.secretKeyForClientConfig(clientConfig)
+		secret := corev1.Secret{}
+		err = m.getUnlabeled(ctx, secretKey, &secret)
+		if err != nil {
+			return errors.Wrapf(err, "failed getting TLS secret %s", secretKey)
+		}
+		err = triple.VerifyTLSAt(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey], clientConfig.CABundle, m.now())
+		if err != nil {
+			return errors.Wrapf(err, "failed verifying TLS from server Secret %s", secretKey)
+		}
+	}
+	return nil
+}
+
+func containsCert(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
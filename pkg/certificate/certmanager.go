@@ -0,0 +1,65 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"crypto/x509"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Annotations cert-manager sets at the secrets it issues [1]
+//
+// [1] https://cert-manager.io/docs/reference/annotations/
+const (
+	certManagerAltNamesAnnotation        = "cert-manager.io/alt-names"
+	certManagerIPSANsAnnotation          = "cert-manager.io/ip-sans"
+	certManagerCommonNameAnnotation      = "cert-manager.io/common-name"
+	certManagerIssuerNameAnnotation      = "cert-manager.io/issuer-name"
+	certManagerIssuerKindAnnotation      = "cert-manager.io/issuer-kind"
+	certManagerIssuerGroupAnnotation     = "cert-manager.io/issuer-group"
+	certManagerCertificateNameAnnotation = "cert-manager.io/certificate-name"
+
+	// certManagerIssuerKind the certificates are issued by the CA secret
+	certManagerIssuerKind = "Secret"
+)
+
+// setCertManagerAnnotations describes the cert stored at secret with the
+// same annotations cert-manager uses, the issuer is the CA secret and the
+// certificate name is the webhook configuration name.
+func (m *Manager) setCertManagerAnnotations(secret *corev1.Secret, cert *x509.Certificate) {
+	if !m.certManagerAnnotations || cert == nil {
+		return
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+
+	ipSANs := []string{}
+	for _, ip := range cert.IPAddresses {
+		ipSANs = append(ipSANs, ip.String())
+	}
+
+	secret.Annotations[certManagerAltNamesAnnotation] = strings.Join(cert.DNSNames, ",")
+	secret.Annotations[certManagerIPSANsAnnotation] = strings.Join(ipSANs, ",")
+	secret.Annotations[certManagerCommonNameAnnotation] = cert.Subject.CommonName
+	secret.Annotations[certManagerIssuerNameAnnotation] = m.caSecretKey().Name
+	secret.Annotations[certManagerIssuerKindAnnotation] = certManagerIssuerKind
+	secret.Annotations[certManagerIssuerGroupAnnotation] = ""
+	secret.Annotations[certManagerCertificateNameAnnotation] = m.webhookName
+}
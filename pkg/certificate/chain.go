@@ -0,0 +1,90 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"crypto/x509"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate/triple"
+)
+
+// TLSCertChain selects the certificates written at the TLS secrets
+// "tls.crt", the service certificates are issued directly by the CA so
+// there are no intermediate certificates
+type TLSCertChain string
+
+const (
+	// TLSCertChainOverlap writes the current certificate followed by the
+	// previous ones until they are cleaned up, sorted by expiration
+	// newest first
+	TLSCertChainOverlap TLSCertChain = "Overlap"
+
+	// TLSCertChainLeaf writes only the current certificate, for TLS
+	// clients and scanners confused by the previous certificates
+	TLSCertChainLeaf TLSCertChain = "Leaf"
+)
+
+// tlsCertsPEM returns the "tls.crt" content following Options.TLSCertChain,
+// the leaf certificate, the one matching the TLS key, is always the first
+// and with Options.IncludeCAInTLSCert its issuer at caBundle the last.
+func (m *Manager) tlsCertsPEM(certsPEM []byte, leaf *x509.Certificate, caBundle []byte) ([]byte, error) {
+	chain := []*x509.Certificate{leaf}
+	if m.tlsCertChain != TLSCertChainLeaf {
+		certs, err := triple.ParseCertsPEM(certsPEM)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed parsing TLS certs to sort them")
+		}
+		previousCerts := []*x509.Certificate{}
+		for _, cert := range leafCerts(certs) {
+			if !cert.Equal(leaf) {
+				previousCerts = append(previousCerts, cert)
+			}
+		}
+		sort.SliceStable(previousCerts, func(i, j int) bool {
+			return previousCerts[i].NotAfter.After(previousCerts[j].NotAfter)
+		})
+		chain = append(chain, previousCerts...)
+	}
+
+	if m.includeCAInTLSCert {
+		cas, err := triple.ParseCertsPEM(caBundle)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed parsing CABundle to include the CA at TLS certs")
+		}
+		issuer := triple.FindIssuer(leaf, cas)
+		if issuer == nil {
+			return nil, errors.New("CA bundle has no issuer for the TLS certificate")
+		}
+		chain = append(chain, issuer)
+	}
+	return triple.EncodeCertsPEM(chain), nil
+}
+
+// leafCerts filters out the CA certificates appended to "tls.crt" by
+// Options.IncludeCAInTLSCert
+func leafCerts(certs []*x509.Certificate) []*x509.Certificate {
+	leafs := []*x509.Certificate{}
+	for _, cert := range certs {
+		if !cert.IsCA {
+			leafs = append(leafs, cert)
+		}
+	}
+	return leafs
+}
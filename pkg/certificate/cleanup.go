@@ -17,6 +17,7 @@
 package certificate
 
 import (
+	"context"
 	"crypto/x509"
 	"fmt"
 	"time"
@@ -29,8 +30,8 @@ import (
 	"github.com/qinqon/kube-admission-webhook/pkg/certificate/triple"
 )
 
-func (m *Manager) earliestElapsedForCACertsCleanup() (time.Duration, error) {
-	cas, err := m.getCACertsFromCABundle()
+func (m *Manager) earliestElapsedForCACertsCleanup(ctx context.Context) (time.Duration, error) {
+	cas, err := m.getCACertsFromCABundle(ctx)
 	if err != nil {
 		return time.Duration(0), errors.Wrap(err, "failed getting CA certificates from CA bundle")
 	}
@@ -40,8 +41,8 @@ func (m *Manager) earliestElapsedForCACertsCleanup() (time.Duration, error) {
 // earliestElapsedForServiceCertsCleanup will iterate all the services and
 // retrieve the secrets associate, calculate the elapsed time for
 // cleanup for each and return the min.
-func (m *Manager) earliestElapsedForServiceCertsCleanup() (time.Duration, error) {
-	webhookConf, err := m.readyWebhookConfiguration()
+func (m *Manager) earliestElapsedForServiceCertsCleanup(ctx context.Context) (time.Duration, error) {
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
 	if err != nil {
 		return time.Duration(0), fmt.Errorf("failed getting webhook configuration to calculate cleanup next run: %w", err)
 	}
@@ -53,7 +54,7 @@ func (m *Manager) earliestElapsedForServiceCertsCleanup() (time.Duration, error)
 
 	elapsedTimesForCleanup := []time.Duration{}
 	for service := range services {
-		certs, err := m.getTLSCerts(service)
+		certs, err := m.getTLSCerts(ctx, service)
 		if err != nil {
 			return time.Duration(0), fmt.Errorf("failed getting TLS keypair from service %s to calculate cleanup next run: %w", service, err)
 		}
@@ -73,7 +74,7 @@ func (m *Manager) earliestElapsedForCleanup(log logr.Logger, certificates []*x50
 	deadline := m.earliestCleanupDeadlineForCerts(certificates)
 	now := m.now()
 	elapsedForCleanup := deadline.Sub(now)
-	log.Info(fmt.Sprintf("{now: %s, deadline: %s, elapsedForCleanup: %s}", now, deadline, elapsedForCleanup))
+	log.V(1).Info(fmt.Sprintf("{now: %s, deadline: %s, elapsedForCleanup: %s}", now, deadline, elapsedForCleanup))
 	return elapsedForCleanup, nil
 }
 
@@ -98,10 +99,10 @@ func (m *Manager) earliestCleanupDeadlineForCerts(certificates []*x509.Certifica
 	return selectedCertificate.NotAfter
 }
 
-func (m *Manager) cleanUpCABundle() error {
+func (m *Manager) cleanUpCABundle(ctx context.Context) error {
 	m.log.Info("cleanUpCABundle")
-	err := m.updateWebhookCABundleWithFunc(func([]byte) ([]byte, error) {
-		cas, err := m.getCACertsFromCABundle()
+	err := m.updateWebhookCABundleWithFunc(ctx, func([]byte) ([]byte, error) {
+		cas, err := m.getCACertsFromCABundle(ctx)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed getting ca certs to start cleanup")
 		}
@@ -113,12 +114,23 @@ func (m *Manager) cleanUpCABundle() error {
 	if err != nil {
 		return errors.Wrap(err, "failed updating webhook config after ca certificates cleanup")
 	}
+
+	err = m.applyCATrustStore(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed updating CA trust store after ca certificates cleanup")
+	}
+
+	err = m.applyCABundleToTLSSecrets(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed updating TLS secrets after ca certificates cleanup")
+	}
+	m.audit(ctx, AuditActionCleanUpCABundle, "expired CA certificates removed from CABundle", m.chainObjects(ctx)...)
 	return nil
 }
 
-func (m *Manager) cleanUpServiceCerts() error {
+func (m *Manager) cleanUpServiceCerts(ctx context.Context) error {
 	m.log.Info("cleanUpServiceCerts")
-	webhookConf, err := m.readyWebhookConfiguration()
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
 	if err != nil {
 		return fmt.Errorf("failed getting webhook configuration to do the cleanup: %w", err)
 	}
@@ -129,7 +141,7 @@ func (m *Manager) cleanUpServiceCerts() error {
 	}
 
 	for service := range services {
-		applyErr := m.applySecret(service, corev1.SecretTypeTLS, nil,
+		applyErr := m.applySecret(ctx, service, corev1.SecretTypeTLS, nil,
 			func(secret *corev1.Secret, keyPair *triple.KeyPair) (*corev1.Secret, error) {
 				certPEM, found := secret.Data[corev1.TLSCertKey]
 				if !found {
@@ -150,6 +162,7 @@ func (m *Manager) cleanUpServiceCerts() error {
 			return applyErr
 		}
 	}
+	m.audit(ctx, AuditActionCleanUpServiceCerts, "expired certificates removed from services secrets", m.chainObjects(ctx)...)
 	return nil
 }
 
@@ -164,7 +177,7 @@ func (m *Manager) cleanUpCertificates(certificates []*x509.Certificate) []*x509.
 	// create a zero-length slice with the same underlying array
 	cleanedUpCertificates := certificates[:0]
 	for _, certificate := range certificates {
-		logger.Info("Checking certificate for cleanup", "now", now, "NotBefore", certificate.NotBefore, "NotAfter", certificate.NotAfter)
+		logger.V(1).Info("Checking certificate for cleanup", "now", now, "NotBefore", certificate.NotBefore, "NotAfter", certificate.NotAfter)
 
 		// Expired certificate are cleaned up
 		expirationDate := certificate.NotAfter
@@ -32,13 +32,24 @@ const (
 	pollTimeout  = 30 * time.Second
 )
 
-// get wraps controller-runtime client `Get` to ensure that client cache
+// clientStorage is the default Storage, it keeps the certificate chain at
+// the cluster using the controller-runtime client.
+type clientStorage struct {
+	client client.Client
+}
+
+// Get wraps controller-runtime client `Get` to ensure that client cache
 // is ready, sometimes after controller-runtime manager is ready the
 // cache is still not ready, specially if you webhook or plain runnable
 // is being used since it miss some controller bits.
-func (m *Manager) get(key types.NamespacedName, value client.Object) error {
-	return wait.PollImmediate(pollInterval, pollTimeout, func() (bool, error) {
-		err := m.client.Get(context.TODO(), key, value)
+func (s *clientStorage) Get(ctx context.Context, key types.NamespacedName, value client.Object) error {
+	return getWhenCacheStarted(ctx, s.client, key, value)
+}
+
+// getWhenCacheStarted retries the client Get until the cache is started
+func getWhenCacheStarted(ctx context.Context, cli client.Client, key types.NamespacedName, value client.Object) error {
+	return wait.PollImmediateWithContext(ctx, pollInterval, pollTimeout, func(ctx context.Context) (bool, error) {
+		err := cli.Get(ctx, key, value)
 		if err != nil {
 			if _, cacheNotStarted := err.(*cache.ErrCacheNotStarted); cacheNotStarted {
 				return false, nil
@@ -49,3 +60,11 @@ func (m *Manager) get(key types.NamespacedName, value client.Object) error {
 		return true, nil
 	})
 }
+
+func (s *clientStorage) Create(ctx context.Context, obj client.Object) error {
+	return s.client.Create(ctx, obj)
+}
+
+func (s *clientStorage) Update(ctx context.Context, obj client.Object) error {
+	return s.client.Update(ctx, obj)
+}
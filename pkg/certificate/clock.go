@@ -0,0 +1,33 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import "time"
+
+// Clock returns the current time used to issue the certificates and to
+// calculate the rotation and cleanup deadlines, tests can plug a fake one
+// to move the time forward without waiting for expirations.
+type Clock interface {
+	Now() time.Time
+}
+
+// RealClock is the default Clock, it returns time.Now
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// secretOwner is stored at the managed annotation value so secrets shared
+// by different webhooks can be detected, secrets created by older versions
+// have an empty value.
+func (m *Manager) secretOwner() string {
+	return fmt.Sprintf("%s/%s", m.webhookType, m.webhookName)
+}
+
+// SecretOwner returns the webhook configuration managing the secret from
+// its managed annotation, found is false for secrets not managed or
+// created by older versions without owner.
+func SecretOwner(secret metav1.Object) (webhookName string, webhookType WebhookType, found bool) {
+	owner := secret.GetAnnotations()[secretManagedAnnotatoinKey]
+	ownerType, ownerName, found := strings.Cut(owner, "/")
+	if !found || ownerName == "" {
+		return "", "", false
+	}
+	return ownerName, WebhookType(ownerType), true
+}
+
+func (m *Manager) setSecretOwner(object metav1.Object) {
+	annotations := object.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[secretManagedAnnotatoinKey] = m.secretOwner()
+	object.SetAnnotations(annotations)
+}
+
+// checkSecretCollisions fails if the CA secret or any of the service
+// secrets is managed by a different webhook, since both Managers would
+// overwrite each other certificates, or if it already exists without the
+// managed annotation and AdoptExistingSecrets is not set.
+func (m *Manager) checkSecretCollisions(ctx context.Context) error {
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed reading webhook configuration to check secret collisions")
+	}
+
+	// The shared CA secret is managed by other webhooks too
+	secretKeys := []types.NamespacedName{}
+	if m.sharedCASecret == nil {
+		secretKeys = append(secretKeys, m.caSecretKey())
+	}
+	for _, clientConfig := range m.clientConfigList(webhookConf) {
+		secretKeys = append(secretKeys, m.secretKeyForClientConfig(clientConfig))
+	}
+
+	for _, secretKey := range secretKeys {
+		secret := corev1.Secret{}
+		err = m.getUnlabeled(ctx, secretKey, &secret)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return errors.Wrapf(err, "failed reading secret %s to check collisions", secretKey)
+		}
+
+		owner, managed := secret.Annotations[secretManagedAnnotatoinKey]
+		if !managed {
+			if m.adoptExistingSecrets {
+				continue
+			}
+			return errors.Errorf("secret %s already exists and is not managed by kube-admission-webhook, "+
+				"remove it or set AdoptExistingSecrets", secretKey)
+		}
+		if owner != "" && owner != m.secretOwner() {
+			return errors.Errorf("secret %s is already managed by webhook %s, "+
+				"services from different webhooks can not share a secret", secretKey, owner)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the file representation of the Options that can be
+// serialized, durations are strings like "24h". Notifiers, Issuer,
+// Storage, RateLimiter, AuditRecorder and Clock have to be set at the
+// loaded Options.
+type Config struct {
+	WebhookName                  string                `json:"webhookName"`
+	WebhookType                  WebhookType           `json:"webhookType,omitempty"`
+	Namespace                    string                `json:"namespace"`
+	CANamespace                  string                `json:"caNamespace,omitempty"`
+	CARotateInterval             metav1.Duration       `json:"caRotateInterval,omitempty"`
+	CAOverlapInterval            metav1.Duration       `json:"caOverlapInterval,omitempty"`
+	CertRotateInterval           metav1.Duration       `json:"certRotateInterval,omitempty"`
+	CertOverlapInterval          metav1.Duration       `json:"certOverlapInterval,omitempty"`
+	CARenewBefore                metav1.Duration       `json:"caRenewBefore,omitempty"`
+	CertRenewBefore              metav1.Duration       `json:"certRenewBefore,omitempty"`
+	RenewFraction                float64               `json:"renewFraction,omitempty"`
+	RelaxFailurePolicyOnRotation bool                  `json:"relaxFailurePolicyOnRotation,omitempty"`
+	OLMPolicy                    OLMPolicy             `json:"olmPolicy,omitempty"`
+	ManageCABundle               *bool                 `json:"manageCABundle,omitempty"`
+	CABundleSource               *types.NamespacedName `json:"caBundleSource,omitempty"`
+	MonitorOnly                  bool                  `json:"monitorOnly,omitempty"`
+	NotificationThreshold        int                   `json:"notificationThreshold,omitempty"`
+	NeedLeaderElection           *bool                 `json:"needLeaderElection,omitempty"`
+	LockCARotation               bool                  `json:"lockCARotation,omitempty"`
+	SharedCASecret               *types.NamespacedName `json:"sharedCASecret,omitempty"`
+	CleanupAcknowledgements      int                   `json:"cleanupAcknowledgements,omitempty"`
+	RestartWorkloads             []Workload            `json:"restartWorkloads,omitempty"`
+	TLSCertChain                 TLSCertChain          `json:"tlsCertChain,omitempty"`
+	IncludeCAInTLSCert           bool                  `json:"includeCAInTLSCert,omitempty"`
+	HardCutover                  bool                  `json:"hardCutover,omitempty"`
+	ExtraLabels                  map[string]string     `json:"extraLabels,omitempty"`
+	ExtraAnnotations             map[string]string     `json:"extraAnnotations,omitempty"`
+	LabelWebhookConfiguration    bool                  `json:"labelWebhookConfiguration,omitempty"`
+	SANOnlyCertificates          bool                  `json:"sanOnlyCertificates,omitempty"`
+	PrivateKeyEncoding           PrivateKeyEncoding    `json:"privateKeyEncoding,omitempty"`
+	CACertInConfigMap            bool                  `json:"caCertInConfigMap,omitempty"`
+	CATrustStore                 bool                  `json:"caTrustStore,omitempty"`
+	CATrustStorePassword         string                `json:"caTrustStorePassword,omitempty"`
+	CertManagerAnnotations       bool                  `json:"certManagerAnnotations,omitempty"`
+	AdoptExistingSecrets         bool                  `json:"adoptExistingSecrets,omitempty"`
+	AdoptCASecret                *types.NamespacedName `json:"adoptCASecret,omitempty"`
+	MaxConcurrentReconciles      int                   `json:"maxConcurrentReconciles,omitempty"`
+	CacheSyncTimeout             metav1.Duration       `json:"cacheSyncTimeout,omitempty"`
+	SelfTestInterval             metav1.Duration       `json:"selfTestInterval,omitempty"`
+	ProbeServiceTLS              bool                  `json:"probeServiceTLS,omitempty"`
+	ProbeBeforeCABundleCleanup   bool                  `json:"probeBeforeCABundleCleanup,omitempty"`
+	ServiceIPSANs                bool                  `json:"serviceIPSANs,omitempty"`
+	CanaryRotation               bool                  `json:"canaryRotation,omitempty"`
+	RotationHistoryLimit         int                   `json:"rotationHistoryLimit,omitempty"`
+	WaitForWebhookConfiguration  bool                  `json:"waitForWebhookConfiguration,omitempty"`
+	Service                      *ServiceTemplate      `json:"service,omitempty"`
+	FeatureGates                 map[FeatureGate]bool  `json:"featureGates,omitempty"`
+}
+
+// LoadOptions reads the Config YAML or JSON file at path, like a mounted
+// ConfigMap, and returns its Options. Unknown fields and invalid options
+// fail, the defaults are not set so NewManager can still be called with
+// the loaded Options.
+func LoadOptions(path string) (*Options, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading certificate options file %s", path)
+	}
+	options, err := ParseOptions(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed loading certificate options file %s", path)
+	}
+	return options, nil
+}
+
+// ParseOptions is LoadOptions for the file content.
+func ParseOptions(data []byte) (*Options, error) {
+	config := Config{}
+	err := yaml.UnmarshalStrict(data, &config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed parsing certificate options")
+	}
+
+	options := config.Options()
+	withDefaultsOptions := options.withDefaults()
+	err = withDefaultsOptions.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &options, nil
+}
+
+// Options converts the Config to Options.
+func (c *Config) Options() Options {
+	return Options{
+		WebhookName:                  c.WebhookName,
+		WebhookType:                  c.WebhookType,
+		Namespace:                    c.Namespace,
+		CANamespace:                  c.CANamespace,
+		CARotateInterval:             c.CARotateInterval.Duration,
+		CAOverlapInterval:            c.CAOverlapInterval.Duration,
+		CertRotateInterval:           c.CertRotateInterval.Duration,
+		CertOverlapInterval:          c.CertOverlapInterval.Duration,
+		CARenewBefore:                c.CARenewBefore.Duration,
+		CertRenewBefore:              c.CertRenewBefore.Duration,
+		RenewFraction:                c.RenewFraction,
+		RelaxFailurePolicyOnRotation: c.RelaxFailurePolicyOnRotation,
+		OLMPolicy:                    c.OLMPolicy,
+		ManageCABundle:               c.ManageCABundle,
+		CABundleSource:               c.CABundleSource,
+		MonitorOnly:                  c.MonitorOnly,
+		NotificationThreshold:        c.NotificationThreshold,
+		NeedLeaderElection:           c.NeedLeaderElection,
+		LockCARotation:               c.LockCARotation,
+		SharedCASecret:               c.SharedCASecret,
+		CleanupAcknowledgements:      c.CleanupAcknowledgements,
+		RestartWorkloads:             c.RestartWorkloads,
+		TLSCertChain:                 c.TLSCertChain,
+		IncludeCAInTLSCert:           c.IncludeCAInTLSCert,
+		HardCutover:                  c.HardCutover,
+		ExtraLabels:                  c.ExtraLabels,
+		ExtraAnnotations:             c.ExtraAnnotations,
+		LabelWebhookConfiguration:    c.LabelWebhookConfiguration,
+		SANOnlyCertificates:          c.SANOnlyCertificates,
+		PrivateKeyEncoding:           c.PrivateKeyEncoding,
+		CACertInConfigMap:            c.CACertInConfigMap,
+		CATrustStore:                 c.CATrustStore,
+		CATrustStorePassword:         c.CATrustStorePassword,
+		CertManagerAnnotations:       c.CertManagerAnnotations,
+		AdoptExistingSecrets:         c.AdoptExistingSecrets,
+		AdoptCASecret:                c.AdoptCASecret,
+		MaxConcurrentReconciles:      c.MaxConcurrentReconciles,
+		CacheSyncTimeout:             c.CacheSyncTimeout.Duration,
+		SelfTestInterval:             c.SelfTestInterval.Duration,
+		ProbeServiceTLS:              c.ProbeServiceTLS,
+		ProbeBeforeCABundleCleanup:   c.ProbeBeforeCABundleCleanup,
+		ServiceIPSANs:                c.ServiceIPSANs,
+		CanaryRotation:               c.CanaryRotation,
+		RotationHistoryLimit:         c.RotationHistoryLimit,
+		WaitForWebhookConfiguration:  c.WaitForWebhookConfiguration,
+		Service:                      c.Service,
+		FeatureGates:                 c.FeatureGates,
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate/triple"
+)
+
+// caConfigMapKey is the ConfigMap containing the CA certificate if
+// Options.CACertInConfigMap is set, it has the same name as the CA secret
+// so RBAC rules can refer to both with the same resourceNames.
+func (m *Manager) caConfigMapKey() types.NamespacedName {
+	return m.caSecretKey()
+}
+
+func populateCAConfigMap(configMap *corev1.ConfigMap, caCert *x509.Certificate) {
+	if configMap.Annotations == nil {
+		configMap.Annotations = map[string]string{}
+	}
+	configMap.Annotations[secretManagedAnnotatoinKey] = ""
+	setManagedLabel(configMap)
+	configMap.Data = map[string]string{
+		CACertKey: string(triple.EncodeCertPEM(caCert)),
+	}
+}
+
+func (m *Manager) applyCAConfigMap(ctx context.Context, caCert *x509.Certificate) error {
+	return m.applyConfigMap(ctx, m.caConfigMapKey(), func(configMap *corev1.ConfigMap) {
+		populateCAConfigMap(configMap, caCert)
+	})
+}
+
+func (m *Manager) applyConfigMap(ctx context.Context, configMapKey types.NamespacedName,
+	populateConfigMapFn func(*corev1.ConfigMap)) error {
+	configMap := &corev1.ConfigMap{}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		err := m.get(ctx, configMapKey, configMap)
+		if err != nil {
+			if !apierrors.IsNotFound(err) {
+				return err
+			}
+			newConfigMap := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      configMapKey.Name,
+					Namespace: configMapKey.Namespace,
+				},
+			}
+			populateConfigMapFn(newConfigMap)
+			m.setExtraMetadata(newConfigMap)
+			m.setSecretOwner(newConfigMap)
+			err = m.storage.Create(ctx, newConfigMap)
+			if err != nil {
+				return errors.Wrap(err, "failed creating configmap")
+			}
+			return nil
+		}
+		populateConfigMapFn(configMap)
+		m.setExtraMetadata(configMap)
+		m.setSecretOwner(configMap)
+		err = m.storage.Update(ctx, configMap)
+		if err != nil {
+			return errors.Wrap(err, "failed updating configmap")
+		}
+		return nil
+	})
+}
+
+func (m *Manager) getCACertPEMFromConfigMap(ctx context.Context) ([]byte, error) {
+	configMap := corev1.ConfigMap{}
+	err := m.get(ctx, m.caConfigMapKey(), &configMap)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed reading ca configmap %s", m.caConfigMapKey())
+	}
+	caCertPEM, found := configMap.Data[CACertKey]
+	if !found {
+		return nil, errors.Errorf("ca cert not found at configmap %s", m.caConfigMapKey())
+	}
+	return []byte(caCertPEM), nil
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/pkg/errors"
 
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -68,26 +69,33 @@ func (m *Manager) clientConfigList(webhook client.Object) []*admissionregistrati
 	return clientConfigList
 }
 
-func (m *Manager) readyWebhookConfiguration() (client.Object, error) {
-	var webhook client.Object
+// emptyWebhookConfiguration returns an empty object of the webhook
+// configuration type to read it into.
+func (m *Manager) emptyWebhookConfiguration() (client.Object, error) {
 	if m.webhookType == MutatingWebhook {
-		webhook = &admissionregistrationv1.MutatingWebhookConfiguration{}
+		return &admissionregistrationv1.MutatingWebhookConfiguration{}, nil
 	} else if m.webhookType == ValidatingWebhook {
-		webhook = &admissionregistrationv1.ValidatingWebhookConfiguration{}
-	} else {
-		return nil, fmt.Errorf("unknown webhook type %s", m.webhookType)
+		return &admissionregistrationv1.ValidatingWebhookConfiguration{}, nil
+	}
+	return nil, fmt.Errorf("unknown webhook type %s", m.webhookType)
+}
+
+func (m *Manager) readyWebhookConfiguration(ctx context.Context) (client.Object, error) {
+	webhook, err := m.emptyWebhookConfiguration()
+	if err != nil {
+		return nil, err
 	}
 	pollInterval := time.Second
 	pollTimeout := 120 * time.Second
 	// Do some polling to wait for manifest to be deployed
-	err := wait.PollImmediate(pollInterval, pollTimeout, func() (bool, error) {
+	err = wait.PollImmediateWithContext(ctx, pollInterval, pollTimeout, func(ctx context.Context) (bool, error) {
 		webhookKey := types.NamespacedName{Name: m.webhookName}
-		err := m.get(webhookKey, webhook)
-		if err != nil {
-			if apierrors.IsNotFound(err) {
+		getErr := m.get(ctx, webhookKey, webhook)
+		if getErr != nil {
+			if apierrors.IsNotFound(getErr) {
 				return false, nil
 			}
-			return false, err
+			return false, getErr
 		}
 		return true, nil
 	})
@@ -97,10 +105,36 @@ func (m *Manager) readyWebhookConfiguration() (client.Object, error) {
 	return webhook, err
 }
 
-func (m *Manager) addCertificateToCABundle(caCert *x509.Certificate) error {
-	m.log.Info("Reset CA bundle with one cert for webhook")
-	err := m.updateWebhookCABundleWithFunc(func(currentCABundle []byte) ([]byte, error) {
+// addCertificateToCABundle prepends caCert to the webhook CABundle, the
+// previous CAs, including the ones injected by other tools, are kept
+// until they expire and cleanUpCABundle removes them.
+func (m *Manager) addCertificateToCABundle(ctx context.Context, caCert *x509.Certificate) error {
+	m.log.Info("Adding CA cert to CA bundle for webhook")
+	err := m.updateWebhookCABundle(ctx, func(currentCABundle []byte) ([]byte, error) {
 		return triple.AddCertToPEM(caCert, currentCABundle, triple.CertsListSizeLimit)
+	}, m.probeServiceTLS)
+	if err != nil {
+		return errors.Wrap(err, "failed to update webhook CABundle")
+	}
+	return nil
+}
+
+// replaceCABundle replaces the CA at the CA secret with caCert at the
+// webhook CABundle, used by Options.HardCutover. The CAs injected by other
+// tools are kept until they expire so the webhooks they trust keep working.
+func (m *Manager) replaceCABundle(ctx context.Context, caCert *x509.Certificate) error {
+	m.log.Info("Replacing CA bundle for webhook")
+	var previousCACert *x509.Certificate
+	previousCAKeyPair, err := m.getCAKeyPair(ctx)
+	if err == nil {
+		previousCACert = previousCAKeyPair.Cert
+	}
+	err = m.updateWebhookCABundleWithFunc(ctx, func(currentCABundle []byte) ([]byte, error) {
+		foreignCAs, foreignCAsErr := m.foreignCAs(currentCABundle, previousCACert)
+		if foreignCAsErr != nil {
+			return nil, foreignCAsErr
+		}
+		return triple.EncodeCertsPEM(append([]*x509.Certificate{caCert}, foreignCAs...)), nil
 	})
 	if err != nil {
 		return errors.Wrap(err, "failed to update webhook CABundle")
@@ -108,12 +142,47 @@ func (m *Manager) addCertificateToCABundle(caCert *x509.Certificate) error {
 	return nil
 }
 
-func (m *Manager) updateWebhookCABundleWithFunc(updateCABundle func([]byte) ([]byte, error)) error {
+// foreignCAs returns the not expired certificates at caBundle that are
+// not the Manager CA.
+func (m *Manager) foreignCAs(caBundle []byte, caCert *x509.Certificate) ([]*x509.Certificate, error) {
+	if len(caBundle) == 0 {
+		return nil, nil
+	}
+	cas, err := triple.ParseCertsPEM(caBundle)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed parsing current CA bundle")
+	}
+	now := m.now()
+	foreignCAs := []*x509.Certificate{}
+	for _, ca := range cas {
+		if caCert != nil && ca.Equal(caCert) {
+			continue
+		}
+		if !now.Before(ca.NotAfter) {
+			continue
+		}
+		foreignCAs = append(foreignCAs, ca)
+	}
+	return foreignCAs, nil
+}
+
+func (m *Manager) updateWebhookCABundleWithFunc(ctx context.Context, updateCABundle func([]byte) ([]byte, error)) error {
+	return m.updateWebhookCABundle(ctx, updateCABundle, false)
+}
+
+// updateWebhookCABundle with probe set checks that the webhooks serve a
+// certificate trusted by the updated CABundle before publishing it, it's
+// only set when a CA is added, the removal of the previous ones is gated by
+// Options.ProbeBeforeCABundleCleanup that postpones it instead of failing.
+func (m *Manager) updateWebhookCABundle(ctx context.Context, updateCABundle func([]byte) ([]byte, error), probe bool) error {
+	if !m.manageCABundle {
+		return m.updateCASecretCABundleWithFunc(ctx, updateCABundle)
+	}
 	m.log.Info("Updating CA bundle for webhook")
 	var webhook client.Object
 	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		var err error
-		webhook, err = m.readyWebhookConfiguration()
+		webhook, err = m.readyWebhookConfiguration(ctx)
 		if err != nil {
 			return errors.Wrapf(err, "failed to get %s webhook configuration %s", m.webhookType, m.webhookName)
 		}
@@ -125,10 +194,22 @@ func (m *Manager) updateWebhookCABundleWithFunc(updateCABundle func([]byte) ([]b
 			if err != nil {
 				return errors.Wrap(err, "failed updating CA bundle")
 			}
+			// Only probe already published bundles, at bootstrap the
+			// webhook can not be serving a certificate yet
+			if probe && len(clientConfig.CABundle) > 0 {
+				err = probeServedCertificate(ctx, clientConfig, updatedCABundle)
+				if err != nil {
+					return errors.Wrap(err, "failed probing webhook TLS with updated CA bundle")
+				}
+			}
 			clientConfig.CABundle = updatedCABundle
 		}
 
-		err = m.client.Update(context.TODO(), webhook)
+		if m.labelWebhookConfiguration {
+			m.setExtraMetadata(webhook)
+		}
+
+		err = m.client.Update(ctx, webhook)
 		if err != nil {
 			return err
 		}
@@ -140,8 +221,30 @@ func (m *Manager) updateWebhookCABundleWithFunc(updateCABundle func([]byte) ([]b
 	return nil
 }
 
+// CABundle returns the CABundle maintained by the Manager.
+//
+// Deprecated: use CABundleWithContext.
 func (m *Manager) CABundle() ([]byte, error) {
-	webhook, err := m.readyWebhookConfiguration()
+	return m.CABundleWithContext(context.Background())
+}
+
+// CABundleWithContext returns the CABundle maintained by the Manager, the
+// one at the webhook configuration or the one at the CA secret if
+// Options.ManageCABundle is false.
+func (m *Manager) CABundleWithContext(ctx context.Context) ([]byte, error) {
+	if !m.manageCABundle {
+		caSecret := corev1.Secret{}
+		err := m.get(ctx, m.caSecretKey(), &caSecret)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, errors.Wrap(err, "failed reading CA secret")
+		}
+		return caSecret.Data[CABundleKey], nil
+	}
+
+	webhook, err := m.readyWebhookConfiguration(ctx)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to get %s webhook configuration %s", m.webhookType, m.webhookName)
 	}
@@ -185,3 +288,42 @@ func (m *Manager) getServicesFromConfiguration(configuration client.Object) (map
 	}
 	return services, nil
 }
+
+// serviceIPs returns the ClusterIPs and LoadBalancer ingress IPs of service
+// if Options.ServiceIPSANs is set, webhooks configured with URL have no
+// service so they have no IPs.
+func (m *Manager) serviceIPs(ctx context.Context, serviceKey types.NamespacedName) ([]string, error) {
+	if !m.serviceIPSANs {
+		return nil, nil
+	}
+	service := corev1.Service{}
+	err := m.get(ctx, serviceKey, &service)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed reading service %s", serviceKey)
+	}
+
+	ips := []string{}
+	for _, clusterIP := range service.Spec.ClusterIPs {
+		if clusterIP != corev1.ClusterIPNone {
+			ips = append(ips, clusterIP)
+		}
+	}
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			ips = append(ips, ingress.IP)
+		}
+	}
+	return ips, nil
+}
+
+// clientConfigCABundle returns the CABundle maintained by the Manager for
+// clientConfig
+func (m *Manager) clientConfigCABundle(ctx context.Context, clientConfig *admissionregistrationv1.WebhookClientConfig) ([]byte, error) {
+	if m.manageCABundle {
+		return clientConfig.CABundle, nil
+	}
+	return m.CABundleWithContext(ctx)
+}
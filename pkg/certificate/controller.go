@@ -25,6 +25,7 @@ import (
 
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -44,45 +45,85 @@ func (m *Manager) Add(mgr manager.Manager) error {
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func (m *Manager) add(mgr manager.Manager) error {
 	logger := m.log.WithName("add")
-	// Create a new controller
-	c, err := controller.New("certificate-controller", mgr, controller.Options{Reconciler: m})
+	registerMetrics()
+	m.apiReader = mgr.GetAPIReader()
+
+	// Create a new controller, it's added to mgr with the configured
+	// leader election
+	c, err := controller.NewUnmanaged("certificate-controller", mgr, controller.Options{
+		Reconciler:              m,
+		RateLimiter:             m.rateLimiter,
+		MaxConcurrentReconciles: m.maxConcurrentReconciles,
+		CacheSyncTimeout:        m.cacheSyncTimeout,
+	})
 	if err != nil {
 		return errors.Wrap(err, "failed instanciating certificate controller")
 	}
+	err = mgr.Add(m.withLeaderElection(m.withWebhookConfigurationWait(m.withPreflight(c))))
+	if err != nil {
+		return errors.Wrap(err, "failed adding certificate controller")
+	}
 
 	// Watch only events for selected m.webhookName
 	onEventForThisWebhook := predicate.Funcs{
 		CreateFunc: func(createEvent event.CreateEvent) bool {
-			return m.isWebhookConfig(createEvent.Object) || (isAnnotatedResource(createEvent.Object) && m.isGeneratedSecret(createEvent.Object))
+			return m.isWebhookConfig(createEvent.Object) || m.isCABundleSource(createEvent.Object) ||
+				(isManagedResource(createEvent.Object) && m.isGeneratedSecret(createEvent.Object))
 		},
 		DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
-			return isAnnotatedResource(deleteEvent.Object) && m.isGeneratedSecret(deleteEvent.Object)
+			return m.isCABundleSource(deleteEvent.Object) ||
+				(isManagedResource(deleteEvent.Object) && m.isGeneratedSecret(deleteEvent.Object))
 		},
 		UpdateFunc: func(updateEvent event.UpdateEvent) bool {
-			return m.isWebhookConfig(updateEvent.ObjectOld) ||
-				(isAnnotatedResource(updateEvent.ObjectOld) && m.isGeneratedSecret(updateEvent.ObjectOld))
+			return m.isWebhookConfig(updateEvent.ObjectOld) || m.isCABundleSource(updateEvent.ObjectOld) ||
+				(isManagedResource(updateEvent.ObjectOld) && m.isGeneratedSecret(updateEvent.ObjectOld))
 		},
 		GenericFunc: func(genericEvent event.GenericEvent) bool {
-			return m.isWebhookConfig(genericEvent.Object) || (isAnnotatedResource(genericEvent.Object) && m.isGeneratedSecret(genericEvent.Object))
+			return m.isWebhookConfig(genericEvent.Object) || m.isCABundleSource(genericEvent.Object) ||
+				(isManagedResource(genericEvent.Object) && m.isGeneratedSecret(genericEvent.Object))
 		},
 	}
 
+	// Reconcile handles the whole certificate chain so all the events are
+	// enqueued with the same key, this way the workqueue deduplicates them
+	// and never reconcile the chain concurrently when
+	// MaxConcurrentReconciles > 1
+	enqueueWebhook := handler.EnqueueRequestsFromMapFunc(func(client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: m.webhookName}}}
+	})
+
+	if m.selfTestInterval > 0 {
+		logger.Info("Starting webhook self test")
+		err = mgr.Add(m.withLeaderElection(manager.RunnableFunc(m.runSelfTest)))
+		if err != nil {
+			return errors.Wrap(err, "failed adding webhook self test")
+		}
+	}
+
 	logger.Info("Starting to watch secrets")
-	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForObject{}, onEventForThisWebhook)
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, enqueueWebhook, onEventForThisWebhook)
 	if err != nil {
 		return errors.Wrap(err, "failed watching Secret")
 	}
 
+	if m.caCertInConfigMap {
+		logger.Info("Starting to watch configmaps")
+		err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, enqueueWebhook, onEventForThisWebhook)
+		if err != nil {
+			return errors.Wrap(err, "failed watching ConfigMap")
+		}
+	}
+
 	logger.Info("Starting to watch validatingwebhookconfiguration")
 	err = c.Watch(&source.Kind{Type: &admissionregistrationv1.ValidatingWebhookConfiguration{}},
-		&handler.EnqueueRequestForObject{}, onEventForThisWebhook)
+		enqueueWebhook, onEventForThisWebhook)
 	if err != nil {
 		return errors.Wrap(err, "failed watching ValidatingWebhookConfiguration")
 	}
 
 	logger.Info("Starting to watch mutatingwebhookconfiguration")
 	err = c.Watch(&source.Kind{Type: &admissionregistrationv1.MutatingWebhookConfiguration{}},
-		&handler.EnqueueRequestForObject{}, onEventForThisWebhook)
+		enqueueWebhook, onEventForThisWebhook)
 	if err != nil {
 		return errors.Wrap(err, "failed watching MutatingWebhookConfiguration")
 	}
@@ -95,36 +136,52 @@ func isAnnotatedResource(object client.Object) bool {
 	return foundAnnotation
 }
 
+// isManagedResource checks the managed label first since it's the one
+// matched by the NewCache selector, the annotation is kept for objects
+// created before the label was introduced.
+func isManagedResource(object client.Object) bool {
+	return isLabeledResource(object) || isAnnotatedResource(object)
+}
+
 func (m *Manager) isWebhookConfig(object client.Object) bool {
 	return object.GetName() == m.webhookName
 }
 
 func (m *Manager) isCASecret(object client.Object) bool {
-	return object.GetName() == m.caSecretKey().Name
+	caSecretKey := m.caSecretKey()
+	return object.GetNamespace() == caSecretKey.Namespace && object.GetName() == caSecretKey.Name
 }
 
 func (m *Manager) isServiceSecret(object client.Object) bool {
-	webhookConf, err := m.readyWebhookConfiguration()
+	// predicates are not called with a context
+	webhookConf, err := m.readyWebhookConfiguration(context.Background())
 	if err != nil {
-		m.log.Info(fmt.Sprintf("failed checking if it's a generated secret: failed getting webhook configuration: %v", err))
+		m.log.V(1).Info(fmt.Sprintf("failed checking if it's a generated secret: failed getting webhook configuration: %v", err))
 		return false
 	}
 
 	services, err := m.getServicesFromConfiguration(webhookConf)
 	if err != nil {
-		m.log.Info(fmt.Sprintf("failed checking if it's a generated secret: failed getting webhook configuration services: %v", err))
+		m.log.V(1).Info(fmt.Sprintf("failed checking if it's a generated secret: failed getting webhook configuration services: %v", err))
 		return false
 	}
 
 	for service := range services {
-		if object.GetName() == service.Name {
+		if object.GetNamespace() == service.Namespace && object.GetName() == service.Name {
 			return true
 		}
 	}
 	return false
 }
 
+// isGeneratedSecret checks the owner at the managed annotation first, so
+// with hundreds of webhooks a secret event only enqueues the Manager owning
+// it without every Manager reading its webhook configuration, objects
+// created by older versions have no owner and fall back to the names.
 func (m *Manager) isGeneratedSecret(object client.Object) bool {
+	if owner := object.GetAnnotations()[secretManagedAnnotatoinKey]; owner != "" {
+		return owner == m.secretOwner()
+	}
 	return m.isCASecret(object) || m.isServiceSecret(object)
 }
 
@@ -134,20 +191,191 @@ func (m *Manager) isGeneratedSecret(object client.Object) bool {
 // The Controller will requeue the Request to be processed again if the returned error is non-nil or
 // Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
 func (m *Manager) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	start := time.Now()
+	result, err := m.reconcile(ctx, request)
+	m.trackFailure(NotificationReasonReconcileFailed, err)
+
+	// Verification failures not fixed by rotating back off exponentially
+	// instead of the error requeue rotating at every retry
+	var verificationErr *VerificationError
+	if errors.As(err, &verificationErr) {
+		m.log.Info(verificationErr.Error())
+		m.recordReconcile(start, reconcileResultBackoff, verificationErr.RetryAfter)
+		return reconcile.Result{RequeueAfter: verificationErr.RetryAfter}, nil
+	}
+	if err != nil {
+		m.recordReconcile(start, reconcileResultError, 0)
+	} else {
+		m.recordReconcile(start, reconcileResultSuccess, result.RequeueAfter)
+	}
+	return result, err
+}
+
+func (m *Manager) reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
 	reqLogger := m.log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name).WithName("Reconcile")
-	reqLogger.Info("Reconciling Certificates")
+	reqLogger.V(1).Info("Reconciling Certificates")
+
+	olmOwned, result, err := m.reconcileOLMOwned(ctx)
+	if olmOwned {
+		return result, err
+	}
+
+	if m.caBundleSource != nil {
+		return m.reconcileCABundleSource(ctx)
+	}
+
+	if m.monitorOnly {
+		return m.reconcileMonitorOnly(ctx), nil
+	}
+
+	err = m.prepareReconcile(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	err = m.applyServices(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	err = m.rotateIfForced(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	elapsedToRotateCA := m.elapsedToRotateCAFromLastDeadline(ctx)
+	elapsedToRotateServices := m.elapsedToRotateServicesFromLastDeadline(ctx)
+
+	elapsedToRotateCA, elapsedToRotateServices, err = m.rotateIfNeeded(ctx, elapsedToRotateCA, elapsedToRotateServices)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	elapsedForCABundleCleanup, elapsedForServiceCertsCleanup, err := m.cleanUpIfNeeded(ctx)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// Return the event that is going to happened sonner all services certificates rotation,
+	// services certificate rotation or ca bundle cleanup
+	requeueAfter := m.scheduleNextReconcile(elapsedToRotateCA, elapsedToRotateServices, elapsedForCABundleCleanup,
+		elapsedForServiceCertsCleanup)
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// scheduleNextReconcile returns the soonest of the rotation and cleanup
+// deadlines and records all of them as the Manager schedule.
+func (m *Manager) scheduleNextReconcile(elapsedToRotateCA, elapsedToRotateServices, elapsedForCABundleCleanup,
+	elapsedForServiceCertsCleanup time.Duration) time.Duration {
+	m.log.V(1).Info("Calculating RequeueAfter", "elapsedToRotateCA", elapsedToRotateCA,
+		"elapsedToRotateServices", elapsedToRotateServices, "elapsedForCABundleCleanup",
+		elapsedForCABundleCleanup, "elapsedForServiceCertsCleanup", elapsedForServiceCertsCleanup)
+	requeueAfter := min(elapsedToRotateCA, elapsedToRotateServices, elapsedForCABundleCleanup, elapsedForServiceCertsCleanup)
+
+	now := m.now()
+	m.recordSchedule(Schedule{
+		CARotation:          now.Add(elapsedToRotateCA),
+		ServiceRotation:     now.Add(elapsedToRotateServices),
+		CABundleCleanup:     now.Add(elapsedForCABundleCleanup),
+		ServiceCertsCleanup: now.Add(elapsedForServiceCertsCleanup),
+		RequeueAfter:        requeueAfter,
+	})
+
+	m.log.V(1).Info(fmt.Sprintf("Certificates will be Reconcile on %s", now.Add(requeueAfter)))
+	return requeueAfter
+}
+
+// cleanUpIfNeeded removes the expired CAs from the CABundle and the
+// previous services certificates if their deadline has passed and returns
+// the re-calculated time left to the next cleanups, with
+// Options.CleanupAcknowledgements the cleanup waits for the webhook
+// replicas to acknowledge the new certificates and with
+// Options.ProbeBeforeCABundleCleanup for them to serve them.
+func (m *Manager) cleanUpIfNeeded(ctx context.Context) (time.Duration, time.Duration, error) {
+	acknowledged, err := m.cleanupAcknowledged(ctx)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed checking cleanup acknowledgements")
+	}
+
+	elapsedForCABundleCleanup, err := m.earliestElapsedForCACertsCleanup(ctx)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed getting ca bundle cleanup deadline")
+	}
+	if !acknowledged {
+		elapsedForCABundleCleanup = postponeCleanup(elapsedForCABundleCleanup)
+	}
+	elapsedForCABundleCleanup, err = m.postponeCABundleCleanupIfNotServing(ctx, elapsedForCABundleCleanup)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed probing webhooks before CABundle cleanup")
+	}
 
-	elapsedToRotateCA := m.elapsedToRotateCAFromLastDeadline()
-	elapsedToRotateServices := m.elapsedToRotateServicesFromLastDeadline()
+	// We have pass cleanup deadline let's do the cleanup
+	if elapsedForCABundleCleanup <= 0 {
+		err = m.cleanUpCABundle(ctx)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "failed cleaning up CABundle")
+		}
+
+		// Re-calculate cleanup deadline since we may have to remove some certs there
+		elapsedForCABundleCleanup, err = m.earliestElapsedForCACertsCleanup(ctx)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "failed re-calculating ca bundle cleanup deadline")
+		}
+	}
+
+	elapsedForServiceCertsCleanup, err := m.earliestElapsedForServiceCertsCleanup(ctx)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed getting service certs cleanup deadline")
+	}
+	if !acknowledged {
+		elapsedForServiceCertsCleanup = postponeCleanup(elapsedForServiceCertsCleanup)
+	}
+
+	// We have pass cleanup deadline let's do the cleanup
+	if elapsedForServiceCertsCleanup <= 0 {
+		err = m.cleanUpServiceCerts(ctx)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "failed cleaning up service certs")
+		}
+
+		// Re-calculate cleanup deadline since we may have to remove some certs there
+		elapsedForServiceCertsCleanup, err = m.earliestElapsedForServiceCertsCleanup(ctx)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "failed re-calculating service certs cleanup deadline")
+		}
+	}
+	return elapsedForCABundleCleanup, elapsedForServiceCertsCleanup, nil
+}
+
+// rotateIfNeeded rotates the CA and services certificates if their
+// deadline has passed or the chain fails verification and returns the
+// re-calculated time left to the next rotations.
+func (m *Manager) rotateIfNeeded(ctx context.Context, elapsedToRotateCA, elapsedToRotateServices time.Duration) (
+	time.Duration, time.Duration, error) {
+	reason := "CA certificate rotation deadline reached"
 
 	// Ensure that this Reconcile is not called after bad changes at
 	// the certificate chain
 	if elapsedToRotateCA > 0 {
-		err := m.verifyTLS()
+		err := m.verifyTLS(ctx)
+		m.trackFailure(NotificationReasonVerificationFailed, err)
+		backoffErr := m.recordVerification(err)
+		if backoffErr != nil {
+			return 0, 0, backoffErr
+		}
 		if err != nil {
-			reqLogger.Info(fmt.Sprintf("TLS certificate chain failed verification, forcing rotation, err: %v", err))
+			m.log.Info(fmt.Sprintf("TLS certificate chain failed verification, forcing rotation, err: %v", err))
 			// Force rotation
 			elapsedToRotateCA = 0
+			reason = fmt.Sprintf("TLS certificate chain failed verification: %v", err)
+		}
+	}
+
+	if elapsedToRotateCA <= 0 && m.lockCARotation {
+		var err error
+		elapsedToRotateCA, err = m.acquireCARotation(ctx)
+		if err != nil {
+			return 0, 0, err
 		}
 	}
 
@@ -155,79 +383,69 @@ func (m *Manager) Reconcile(ctx context.Context, request reconcile.Request) (rec
 	if elapsedToRotateCA <= 0 {
 		// If rotate fails runtime-controller manager will re-enqueue it, so
 		// it will be retried
-		err := m.rotateAll()
+		err := m.rotateWithRollback(ctx, (*Manager).rotateAll)
 		if err != nil {
-			return reconcile.Result{}, errors.Wrap(err, "failed rotating all certs")
+			return 0, 0, errors.Wrap(err, "failed rotating all certs")
 		}
+		m.audit(ctx, AuditActionRotateAll, reason, m.chainObjects(ctx)...)
+		m.restartWorkloads(ctx)
 
 		// Re-calculate elapsedToRotate since we have generated new
 		// certificates
-		m.nextRotationDeadlineForCA()
-		elapsedToRotateCA = m.elapsedToRotateCAFromLastDeadline()
+		m.nextRotationDeadlineForCA(ctx)
+		elapsedToRotateCA = m.elapsedToRotateCAFromLastDeadline(ctx)
 
 		// Also recalculate it for serices certificate since they has changed
-		m.nextRotationDeadlineForServices()
-		elapsedToRotateServices = m.elapsedToRotateServicesFromLastDeadline()
+		m.nextRotationDeadlineForServices(ctx)
+		elapsedToRotateServices = m.elapsedToRotateServicesFromLastDeadline(ctx)
 	} else if elapsedToRotateServices <= 0 {
 		// CA is ok but expiration but we have passed expiration time for service certificates
-		err := m.rotateServicesWithOverlap()
+		err := m.rotateWithRollback(ctx, (*Manager).rotateServicesCerts)
 		if err != nil {
-			return reconcile.Result{}, errors.Wrap(err, "failed rotating services certs")
+			return 0, 0, errors.Wrap(err, "failed rotating services certs")
 		}
+		m.audit(ctx, AuditActionRotateServices, "service certificates rotation deadline reached", m.chainObjects(ctx)...)
+		m.restartWorkloads(ctx)
 
 		// Re-calculate elapsedToRotateServices since we have generated new
 		// services certificates
-		m.nextRotationDeadlineForServices()
-		elapsedToRotateServices = m.elapsedToRotateServicesFromLastDeadline()
+		m.nextRotationDeadlineForServices(ctx)
+		elapsedToRotateServices = m.elapsedToRotateServicesFromLastDeadline(ctx)
 	}
+	return elapsedToRotateCA, elapsedToRotateServices, nil
+}
 
-	elapsedForCABundleCleanup, err := m.earliestElapsedForCACertsCleanup()
+// prepareReconcile labels the objects created by older versions and
+// checks, only at the first Reconcile, that the secrets are not shared
+// with other webhooks and adopts the existing ones if
+// Options.AdoptExistingSecrets is set.
+func (m *Manager) prepareReconcile(ctx context.Context) error {
+	err := m.labelManagedObjectsOnce(ctx)
 	if err != nil {
-		return reconcile.Result{}, errors.Wrap(err, "failed getting ca bundle cleanup deadline")
-	}
-
-	// We have pass cleanup deadline let's do the cleanup
-	if elapsedForCABundleCleanup <= 0 {
-		err = m.cleanUpCABundle()
-		if err != nil {
-			return reconcile.Result{}, errors.Wrap(err, "failed cleaning up CABundle")
-		}
-
-		// Re-calculate cleanup deadline since we may have to remove some certs there
-		elapsedForCABundleCleanup, err = m.earliestElapsedForCACertsCleanup()
-		if err != nil {
-			return reconcile.Result{}, errors.Wrap(err, "failed re-calculating ca bundle cleanup deadline")
-		}
+		return err
 	}
 
-	elapsedForServiceCertsCleanup, err := m.earliestElapsedForServiceCertsCleanup()
+	// Restore the failure policies left relaxed by an interrupted rotation
+	err = m.restoreFailurePolicy(ctx)
 	if err != nil {
-		return reconcile.Result{}, errors.Wrap(err, "failed getting service certs cleanup deadline")
+		return errors.Wrap(err, "failed restoring webhooks failure policy")
 	}
 
-	// We have pass cleanup deadline let's do the cleanup
-	if elapsedForServiceCertsCleanup <= 0 {
-		err = m.cleanUpServiceCerts()
+	if !m.secretCollisionsChecked {
+		err = m.checkSecretCollisions(ctx)
 		if err != nil {
-			return reconcile.Result{}, errors.Wrap(err, "failed cleaning up service certs")
+			return errors.Wrap(err, "failed checking secret collisions")
 		}
+		m.secretCollisionsChecked = true
+	}
 
-		// Re-calculate cleanup deadline since we may have to remove some certs there
-		elapsedForServiceCertsCleanup, err = m.earliestElapsedForServiceCertsCleanup()
+	if m.adoptExistingSecrets {
+		err = m.adoptSecrets(ctx)
 		if err != nil {
-			return reconcile.Result{}, errors.Wrap(err, "failed re-calculating service certs cleanup deadline")
+			return errors.Wrap(err, "failed adopting existing secrets")
 		}
 	}
-
-	// Return the event that is going to happened sonner all services certificates rotation,
-	// services certificate rotation or ca bundle cleanup
-	m.log.Info("Calculating RequeueAfter", "elapsedToRotateCA", elapsedToRotateCA,
-		"elapsedToRotateServices", elapsedToRotateServices, "elapsedForCABundleCleanup",
-		elapsedForCABundleCleanup, "elapsedForServiceCertsCleanup", elapsedForServiceCertsCleanup)
-	requeueAfter := min(elapsedToRotateCA, elapsedToRotateServices, elapsedForCABundleCleanup, elapsedForServiceCertsCleanup)
-
-	m.log.Info(fmt.Sprintf("Certificates will be Reconcile on %s", m.now().Add(requeueAfter)))
-	return reconcile.Result{RequeueAfter: requeueAfter}, nil
+	return nil
 }
 
 func min(values ...time.Duration) time.Duration {
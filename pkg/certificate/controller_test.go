@@ -18,16 +18,21 @@ package certificate
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -39,6 +44,15 @@ var (
 	log = logf.Log.WithName("certificate/manager_test")
 )
 
+// fakeClock only moves forward when the test changes now
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
 var _ = Describe("Certificates controller", func() {
 	var (
 		caCertDuration          = 70 * time.Minute
@@ -46,10 +60,10 @@ var _ = Describe("Certificates controller", func() {
 		serviceCertDuration     = 30 * time.Minute
 		serviceOverlapDuration  = serviceCertDuration / 10
 		mgr                     *Manager
-		now                     time.Time
+		clock                   *fakeClock
 		isTLSEventuallyVerified = func() AsyncAssertion {
 			return Eventually(func() error {
-				return mgr.verifyTLS()
+				return mgr.verifyTLS(context.TODO())
 			}, 20*time.Second, 1*time.Second)
 		}
 
@@ -85,6 +99,9 @@ var _ = Describe("Certificates controller", func() {
 
 	BeforeEach(func() {
 
+		// Freeze time
+		clock = &fakeClock{now: time.Now()}
+
 		var err error
 		mgr, err = NewManager(cli, &Options{
 			WebhookName:         expectedMutatingWebhookConfiguration.Name,
@@ -94,10 +111,9 @@ var _ = Describe("Certificates controller", func() {
 			CAOverlapInterval:   caOverlapDuration,
 			CertRotateInterval:  serviceCertDuration,
 			CertOverlapInterval: serviceOverlapDuration,
+			Clock:               clock,
 		})
 		Expect(err).To(Succeed(), "should succeed constructing certificate manager")
-		// Freeze time
-		now = time.Now()
 
 		createResources()
 	})
@@ -147,9 +163,7 @@ var _ = Describe("Certificates controller", func() {
 			backToTheFuture               = func(step string, future time.Duration) {
 				previousTLS = currentTLS
 				previousResult = currentResult
-				now = mgr.now().Add(future)
-				mgr.now = func() time.Time { return now }
-				triple.Now = mgr.now
+				clock.now = clock.now.Add(future)
 				var err error
 				By(fmt.Sprintf("%s t: %s", step, future))
 				currentResult, err = mgr.Reconcile(context.Background(), reconcile.Request{})
@@ -167,9 +181,16 @@ var _ = Describe("Certificates controller", func() {
 			Expect(currentTLS.serviceSecretAnnotations).To(HaveKey(secretManagedAnnotatoinKey),
 				"should be marked as managed by the kube-admission-webhook cert-manager")
 			Expect(currentResult.RequeueAfter).To(BeNumerically(">", time.Duration(0)), "should not be zero")
-			Expect(currentResult.RequeueAfter).To(Equal(mgr.elapsedToRotateServicesFromLastDeadline()),
+			Expect(currentResult.RequeueAfter).To(Equal(mgr.elapsedToRotateServicesFromLastDeadline(context.TODO())),
 				"should schedule new Reconcile after first Reconcile to rotate service cert")
 		})
+		It("should expose the computed schedule", func() {
+			schedule := mgr.NextSchedule()
+			Expect(schedule.RequeueAfter).To(Equal(currentResult.RequeueAfter), "should match Reconcile RequeueAfter")
+			Expect(schedule.ServiceRotation).To(Equal(mgr.now().Add(currentResult.RequeueAfter)),
+				"should schedule service rotation at next Reconcile")
+			Expect(schedule.CARotation).To(BeTemporally(">", schedule.ServiceRotation), "should rotate CA after service certs")
+		})
 		Context("and then called in the middle of service cert deadline", func() {
 			BeforeEach(func() {
 				backToTheFuture("Reconcile in the middle of service cert deadline", serviceCertDuration/2)
@@ -177,7 +198,7 @@ var _ = Describe("Certificates controller", func() {
 			It("should not rotate service cert and return a reduced deadline", func() {
 				Expect(currentResult.RequeueAfter).To(BeNumerically("<", previousResult.RequeueAfter),
 					"should subsctract 'now' from service cert deadline at reconcile in the middle of service certificate duration")
-				Expect(currentResult.RequeueAfter).To(Equal(mgr.elapsedToRotateServicesFromLastDeadline()),
+				Expect(currentResult.RequeueAfter).To(Equal(mgr.elapsedToRotateServicesFromLastDeadline(context.TODO())),
 					"should schedule new Reconcile rotate service cert")
 				Expect(currentTLS).To(Equal(previousTLS), "should not change TLS cert/key on reconcile in the middle of certificate duration")
 			})
@@ -194,7 +215,7 @@ var _ = Describe("Certificates controller", func() {
 					Expect(currentTLS.caCertificate).To(Equal(previousTLS.caCertificate), "shouldn't have rotate CA certificate")
 					Expect(currentTLS.caPrivateKey).To(Equal(previousTLS.caPrivateKey), "shouldn't have rotate CA key rotation")
 					Expect(currentTLS.caSecretAnnotations).To(Equal(previousTLS.caSecretAnnotations), "should containe same secret annotations")
-					earliestElapsedForServiceCertsCleanup, err := mgr.earliestElapsedForServiceCertsCleanup()
+					earliestElapsedForServiceCertsCleanup, err := mgr.earliestElapsedForServiceCertsCleanup(context.TODO())
 					Expect(err).ToNot(HaveOccurred())
 					Expect(currentResult.RequeueAfter).To(Equal(earliestElapsedForServiceCertsCleanup),
 						"should schedule new Reconcile after service cert rotation to cleanup overlap")
@@ -215,7 +236,7 @@ var _ = Describe("Certificates controller", func() {
 						Expect(currentTLS.caCertificate).To(Equal(previousTLS.caCertificate), "shouldn't have rotate CA certificate")
 						Expect(currentTLS.caPrivateKey).To(Equal(previousTLS.caPrivateKey), "shouldn't have rotate CA key rotation")
 						Expect(currentTLS.caSecretAnnotations).To(Equal(previousTLS.caSecretAnnotations), "should containe same secret annotations")
-						Expect(currentResult.RequeueAfter).To(Equal(mgr.elapsedToRotateServicesFromLastDeadline()),
+						Expect(currentResult.RequeueAfter).To(Equal(mgr.elapsedToRotateServicesFromLastDeadline(context.TODO())),
 							"should schedule new Reconcile after service cert rotation to rotate service cert again")
 
 						certs, err := triple.ParseCertsPEM(currentTLS.serviceCertificate)
@@ -236,7 +257,7 @@ var _ = Describe("Certificates controller", func() {
 							Expect(currentTLS.caCertificate).To(Equal(previousTLS.caCertificate), "shouldn't have rotate CA certificate")
 							Expect(currentTLS.caPrivateKey).To(Equal(previousTLS.caPrivateKey), "shouldn't have rotate CA key rotation")
 							Expect(currentTLS.caSecretAnnotations).To(Equal(previousTLS.caSecretAnnotations), "should containe same secret annotations")
-							earliestElapsedForServiceCertsCleanup, err := mgr.earliestElapsedForServiceCertsCleanup()
+							earliestElapsedForServiceCertsCleanup, err := mgr.earliestElapsedForServiceCertsCleanup(context.TODO())
 							Expect(err).ToNot(HaveOccurred())
 							Expect(currentResult.RequeueAfter).To(Equal(earliestElapsedForServiceCertsCleanup),
 								"should schedule new Reconcile after service cert rotation to cleanup overlap")
@@ -258,7 +279,7 @@ var _ = Describe("Certificates controller", func() {
 								Expect(currentTLS.caCertificate).To(Equal(previousTLS.caCertificate), "shouldn't have rotate CA certificate")
 								Expect(currentTLS.caPrivateKey).To(Equal(previousTLS.caPrivateKey), "shouldn't have rotate CA key rotation")
 								Expect(currentTLS.caSecretAnnotations).To(Equal(previousTLS.caSecretAnnotations), "should containe same secret annotations")
-								Expect(currentResult.RequeueAfter).To(Equal(mgr.elapsedToRotateCAFromLastDeadline()),
+								Expect(currentResult.RequeueAfter).To(Equal(mgr.elapsedToRotateCAFromLastDeadline(context.TODO())),
 									"should schedule new Reconcile after service cert rotation to rotate CA cert")
 
 								certs, err := triple.ParseCertsPEM(currentTLS.serviceCertificate)
@@ -278,7 +299,7 @@ var _ = Describe("Certificates controller", func() {
 									Expect(currentTLS.caCertificate).ToNot(Equal(previousTLS.caCertificate), "should have rotate CA certificate")
 									Expect(currentTLS.caPrivateKey).ToNot(Equal(previousTLS.caPrivateKey), "should have rotate CA key rotation")
 
-									elapsedForCleanup, err := mgr.earliestElapsedForCACertsCleanup()
+									elapsedForCleanup, err := mgr.earliestElapsedForCACertsCleanup(context.TODO())
 									Expect(err).To(Succeed(), "should succeed calculating earliestElapsedForCACertsCleanup")
 									Expect(currentResult.RequeueAfter).To(Equal(elapsedForCleanup),
 										"Reconcile at rotate should schedule next Reconcile to do the CA overlapping cleanup")
@@ -303,7 +324,7 @@ var _ = Describe("Certificates controller", func() {
 										cas, err := triple.ParseCertsPEM(currentTLS.caBundle)
 										Expect(err).To(Succeed(), "should succeed parssing caBundle")
 										Expect(cas).To(HaveLen(1), "should have cleandup CA bundle with expired certificates gone")
-										Expect(currentResult.RequeueAfter).To(Equal(mgr.elapsedToRotateServicesFromLastDeadline()),
+										Expect(currentResult.RequeueAfter).To(Equal(mgr.elapsedToRotateServicesFromLastDeadline(context.TODO())),
 											"should schedule new Reconcile after CA cleanup to rotate service cert")
 									})
 								})
@@ -315,6 +336,393 @@ var _ = Describe("Certificates controller", func() {
 			})
 		})
 	})
+	Context("when webhook configuration is owned by OLM and OLMPolicy is Skip", func() {
+		BeforeEach(func() {
+			obtainedWebhookConfiguration := getWebhookConfiguration()
+			obtainedWebhookConfiguration.Labels = map[string]string{OLMOwnerLabelKey: "foo-operator.v1.0.0"}
+			updateWebhookConfiguration(obtainedWebhookConfiguration)
+			mgr.olmPolicy = OLMPolicySkip
+		})
+		It("should not touch the CABundle nor create the secrets", func() {
+			result, err := mgr.Reconcile(context.Background(), reconcile.Request{})
+			Expect(err).To(Succeed(), "should success reconciling")
+			Expect(result).To(Equal(reconcile.Result{}), "should not requeue")
+			Expect(getWebhookConfiguration().Webhooks[0].ClientConfig.CABundle).To(BeEmpty(), "should not inject CABundle")
+			err = cli.Get(context.TODO(), types.NamespacedName{
+				Namespace: expectedCASecret.Namespace, Name: expectedCASecret.Name}, &corev1.Secret{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue(), "should not create CA secret")
+		})
+	})
+
+	Context("when MonitorOnly is set", func() {
+		BeforeEach(func() {
+			mgr.monitorOnly = true
+		})
+		It("should not write certificates and requeue to verify them again", func() {
+			result, err := mgr.Reconcile(context.Background(), reconcile.Request{})
+			Expect(err).To(Succeed(), "should success reconciling")
+			Expect(result.RequeueAfter).To(Equal(monitorOnlyInterval), "should requeue at monitor interval")
+			Expect(getWebhookConfiguration().Webhooks[0].ClientConfig.CABundle).To(BeEmpty(), "should not inject CABundle")
+			isCASecretEventuallyPresent().Should(BeFalse(), "should not create CA secret")
+		})
+	})
+
+	Context("when notifiers are configured and verification fails repeatedly", func() {
+		var (
+			server   *httptest.Server
+			posted   chan Event
+			events   []Event
+			recorder *record.FakeRecorder
+		)
+		BeforeEach(func() {
+			posted = make(chan Event, 10)
+			events = []Event{}
+			recorder = record.NewFakeRecorder(10)
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				event := Event{}
+				if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				posted <- event
+			}))
+			mgr.monitorOnly = true
+			mgr.notifiers = []Notifier{
+				NewLogNotifier(log),
+				NewEventNotifier(recorder),
+				NewHTTPNotifier(server.URL),
+				NotifierFunc(func(event Event) error {
+					events = append(events, event)
+					return nil
+				}),
+			}
+		})
+		AfterEach(func() {
+			server.Close()
+		})
+		It("should notify every notifier once the threshold is reached", func() {
+			for i := 0; i < defaultNotificationThreshold-1; i++ {
+				_, err := mgr.Reconcile(context.Background(), reconcile.Request{})
+				Expect(err).To(Succeed(), "should success reconciling")
+			}
+			Expect(events).To(BeEmpty(), "should not notify before reaching the threshold")
+
+			_, err := mgr.Reconcile(context.Background(), reconcile.Request{})
+			Expect(err).To(Succeed(), "should success reconciling")
+			Expect(events).To(HaveLen(1), "should notify the func notifier")
+			Expect(events[0].WebhookName).To(Equal(expectedMutatingWebhookConfiguration.Name))
+			Expect(events[0].WebhookType).To(Equal(MutatingWebhook))
+			Expect(events[0].Reason).To(Equal(NotificationReasonVerificationFailed))
+			Expect(events[0].Failures).To(Equal(defaultNotificationThreshold))
+
+			var obtainedEvent Event
+			Eventually(posted).Should(Receive(&obtainedEvent), "should post the event")
+			Expect(obtainedEvent.Reason).To(Equal(NotificationReasonVerificationFailed))
+			Expect(obtainedEvent.Failures).To(Equal(defaultNotificationThreshold))
+
+			Expect(recorder.Events).To(Receive(HavePrefix("Warning "+NotificationReasonVerificationFailed)),
+				"should record a kubernetes event")
+		})
+		It("should not block on a slow HTTP endpoint", func() {
+			unblock := make(chan struct{})
+			slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-unblock
+			}))
+			defer slowServer.Close()
+			defer close(unblock)
+
+			notifier := NewHTTPNotifier(slowServer.URL)
+			event := Event{Reason: NotificationReasonReconcileFailed}
+			Expect(notifier.Notify(event)).To(Succeed(), "should queue the event")
+			Eventually(func() error { return notifier.Notify(event) }).ShouldNot(Succeed(),
+				"should drop the events once the queue is full")
+		})
+	})
+
+	Context("when verification keeps failing after rotating", func() {
+		It("should back off exponentially before rotating again", func() {
+			verificationErr := fmt.Errorf("bad chain")
+			Expect(mgr.recordVerification(verificationErr)).To(Succeed(), "should rotate at the first failure")
+
+			err := mgr.recordVerification(verificationErr)
+			Expect(err).To(BeAssignableToTypeOf(&VerificationError{}), "should back off at the second failure")
+			Expect(err.(*VerificationError).Failures).To(Equal(2))
+			Expect(err.(*VerificationError).RetryAfter).To(Equal(verificationBackoffBase))
+
+			clock.now = clock.now.Add(verificationBackoffBase)
+			Expect(mgr.recordVerification(verificationErr)).To(Succeed(), "should rotate again once backoff has passed")
+
+			err = mgr.recordVerification(verificationErr)
+			Expect(err).To(BeAssignableToTypeOf(&VerificationError{}), "should back off again")
+			Expect(err.(*VerificationError).RetryAfter).To(Equal(4*verificationBackoffBase), "should double the backoff")
+
+			Expect(mgr.recordVerification(nil)).To(Succeed(), "should reset at verification success")
+			Expect(mgr.verificationFailures).To(BeZero())
+			Expect(verificationBackoff(100)).To(Equal(verificationBackoffMax), "should cap the backoff")
+		})
+	})
+
+	Context("when ProbeBeforeCABundleCleanup is set and the webhook is not serving the current CA", func() {
+		BeforeEach(func() {
+			mgr.probeBeforeCABundleCleanup = true
+			_, err := mgr.Reconcile(context.Background(), reconcile.Request{})
+			Expect(err).To(Succeed(), "should success reconciling")
+		})
+		It("should postpone the CABundle cleanup once its deadline has passed", func() {
+			elapsed, err := mgr.postponeCABundleCleanupIfNotServing(context.Background(), 0)
+			Expect(err).To(Succeed(), "should success probing the webhooks")
+			Expect(elapsed).To(Equal(acknowledgementPollInterval), "should probe them again later")
+
+			elapsed, err = mgr.postponeCABundleCleanupIfNotServing(context.Background(), time.Hour)
+			Expect(err).To(Succeed(), "should not probe before the deadline")
+			Expect(elapsed).To(Equal(time.Hour), "should keep the cleanup deadline")
+		})
+	})
+
+	Context("when SANOnlyCertificates is set", func() {
+		BeforeEach(func() {
+			mgr.issuer = tripleIssuer{now: clock.Now, sanOnly: true}
+		})
+		It("should issue services certificates without CommonName", func() {
+			_, err := mgr.Reconcile(context.Background(), reconcile.Request{})
+			Expect(err).To(Succeed(), "should success reconciling")
+
+			certs, err := triple.ParseCertsPEM(getTLS().serviceCertificate)
+			Expect(err).To(Succeed(), "should success parsing service certificate")
+			Expect(certs[0].Subject.CommonName).To(BeEmpty(), "should not set CommonName")
+			Expect(certs[0].DNSNames).ToNot(BeEmpty(), "should set DNS SANs")
+		})
+	})
+
+	Context("when the webhook configuration has the force rotation annotation", func() {
+		It("should rotate all the certificates and remove the annotation", func() {
+			_, err := mgr.Reconcile(context.Background(), reconcile.Request{})
+			Expect(err).To(Succeed(), "should success reconciling")
+			previousTLS := getTLS()
+
+			webhookConfiguration := getWebhookConfiguration()
+			webhookConfiguration.Annotations = map[string]string{ForceRotationAnnotationKey: "test"}
+			updateWebhookConfiguration(webhookConfiguration)
+
+			_, err = mgr.Reconcile(context.Background(), reconcile.Request{})
+			Expect(err).To(Succeed(), "should success reconciling")
+			Expect(getTLS().caCertificate).ToNot(Equal(previousTLS.caCertificate), "should rotate the CA")
+
+			Expect(getWebhookConfiguration().Annotations).ToNot(HaveKey(ForceRotationAnnotationKey), "should remove the annotation")
+		})
+	})
+
+	Context("when Reconcile finishes", func() {
+		It("should record its result per webhook", func() {
+			successes := reconcileTotal.WithLabelValues(expectedMutatingWebhookConfiguration.Name, string(MutatingWebhook), reconcileResultSuccess)
+			previousSuccesses := promtestutil.ToFloat64(successes)
+
+			_, err := mgr.Reconcile(context.Background(), reconcile.Request{})
+			Expect(err).To(Succeed(), "should success reconciling")
+			Expect(promtestutil.ToFloat64(successes)).To(Equal(previousSuccesses+1), "should count the successful reconcile")
+		})
+	})
+
+	Context("when Preflight is called", func() {
+		It("should succeed if the webhook configuration and its services exist", func() {
+			Expect(mgr.Preflight(context.Background())).To(Succeed(), "should pass preflight")
+		})
+		It("should fail if a service referenced by the webhook configuration is missing", func() {
+			Expect(cli.Delete(context.TODO(), expectedService.DeepCopy())).To(Succeed(), "should success deleting service")
+			err := mgr.Preflight(context.Background())
+			Expect(err).ToNot(Succeed(), "should not pass preflight")
+			Expect(err.Error()).To(ContainSubstring("referenced by the webhook configuration not found"))
+		})
+	})
+
+	Context("when a service referenced by the webhook configuration is missing", func() {
+		var (
+			started  bool
+			runnable manager.Runnable
+		)
+		BeforeEach(func() {
+			Expect(cli.Delete(context.TODO(), expectedService.DeepCopy())).To(Succeed(), "should success deleting service")
+			started = false
+		})
+		JustBeforeEach(func() {
+			runnable = mgr.withPreflight(manager.RunnableFunc(func(context.Context) error {
+				started = true
+				return nil
+			}))
+		})
+		It("should start the controller since FeatureGatePreflightOnStart is disabled by default", func() {
+			Expect(mgr.featureEnabled(FeatureGatePreflightOnStart)).To(BeFalse(), "should disable PreflightOnStart by default")
+			Expect(runnable.Start(context.Background())).To(Succeed(), "should start the runnable")
+			Expect(started).To(BeTrue(), "should start the controller")
+		})
+		Context("and FeatureGatePreflightOnStart is enabled", func() {
+			BeforeEach(func() {
+				mgr.featureGates = featureGates(map[FeatureGate]bool{FeatureGatePreflightOnStart: true})
+			})
+			It("should fail starting the controller", func() {
+				Expect(runnable.Start(context.Background())).ToNot(Succeed(), "should fail the preflight checks")
+				Expect(started).To(BeFalse(), "should not start the controller")
+			})
+		})
+	})
+
+	Context("when WaitForWebhookConfiguration is set and the webhook configuration does not exist yet", func() {
+		var (
+			started chan struct{}
+			cancel  context.CancelFunc
+		)
+		BeforeEach(func() {
+			mgr.waitForWebhookConfiguration = true
+			Expect(cli.Delete(context.TODO(), expectedMutatingWebhookConfiguration.DeepCopy())).To(Succeed(),
+				"should success deleting mutatingwebhookconfiguration")
+
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			started = make(chan struct{})
+			runnable := mgr.withWebhookConfigurationWait(manager.RunnableFunc(func(context.Context) error {
+				close(started)
+				return nil
+			}))
+			go func() {
+				defer GinkgoRecover()
+				Expect(runnable.Start(ctx)).To(Succeed(), "should start the runnable")
+			}()
+		})
+		AfterEach(func() {
+			cancel()
+		})
+		It("should start the controller once the webhook configuration is created", func() {
+			Consistently(started, 2*time.Second).ShouldNot(BeClosed(), "should wait for the webhook configuration")
+
+			Expect(cli.Create(context.TODO(), expectedMutatingWebhookConfiguration.DeepCopy())).To(Succeed(),
+				"should success creating mutatingwebhookconfiguration")
+			Eventually(started, 2*webhookConfigurationWaitInterval).Should(BeClosed(), "should start after the webhook configuration is created")
+		})
+	})
+
+	Context("when Service is set", func() {
+		BeforeEach(func() {
+			mgr.service = &ServiceTemplate{Selector: map[string]string{"app": "webhook"}, TargetPort: 8443}
+			Expect(cli.Delete(context.TODO(), expectedService.DeepCopy())).To(Succeed(), "should success deleting service")
+			Eventually(func() bool {
+				err := cli.Get(context.TODO(), types.NamespacedName{Namespace: expectedService.Namespace, Name: expectedService.Name},
+					&corev1.Service{})
+				return apierrors.IsNotFound(err)
+			}, 10*time.Second, time.Second).Should(BeTrue(), "should eventually delete the service")
+		})
+		It("should create the service referenced by the webhook configuration", func() {
+			_, err := mgr.Reconcile(context.Background(), reconcile.Request{})
+			Expect(err).To(Succeed(), "should success reconciling")
+
+			service := corev1.Service{}
+			err = cli.Get(context.TODO(), types.NamespacedName{Namespace: expectedService.Namespace, Name: expectedService.Name}, &service)
+			Expect(err).To(Succeed(), "should success getting the created service")
+			Expect(service.Spec.Selector).To(Equal(mgr.service.Selector), "should select the webhook pods")
+			Expect(service.Spec.Ports).To(HaveLen(1), "should have the webhook port")
+			Expect(service.Spec.Ports[0].Port).To(Equal(int32(defaultServicePort)), "should use the serviceRef port")
+			Expect(service.Spec.Ports[0].TargetPort.IntValue()).To(Equal(8443), "should use the template target port")
+		})
+	})
+
+	Context("when AdoptCASecret is set and the TLS secret was issued by another CA", func() {
+		var (
+			adoptedCASecret corev1.Secret
+			adoptedCA       *triple.KeyPair
+			adoptedTLS      *triple.KeyPair
+		)
+		BeforeEach(func() {
+			var err error
+			adoptedCA, err = triple.NewCA("external-ca", 365*24*time.Hour)
+			Expect(err).To(Succeed(), "should success generating external CA")
+			adoptedTLS, err = triple.NewServerKeyPair(adoptedCA, expectedService.Name+"."+expectedService.Namespace+".svc",
+				expectedService.Name, expectedService.Namespace, "cluster.local", nil, nil, 365*24*time.Hour)
+			Expect(err).To(Succeed(), "should success generating external TLS key pair")
+
+			adoptedCASecret = corev1.Secret{Type: corev1.SecretTypeTLS, Data: map[string][]byte{
+				corev1.TLSCertKey:       triple.EncodeCertPEM(adoptedCA.Cert),
+				corev1.TLSPrivateKeyKey: triple.EncodePrivateKeyPEM(adoptedCA.Key),
+			}}
+			adoptedCASecret.Namespace, adoptedCASecret.Name = expectedNamespace.Name, "external-ca"
+			Expect(cli.Create(context.TODO(), &adoptedCASecret)).To(Succeed(), "should success creating external CA secret")
+
+			tlsSecret := corev1.Secret{Type: corev1.SecretTypeTLS, Data: map[string][]byte{
+				corev1.TLSCertKey:       triple.EncodeCertPEM(adoptedTLS.Cert),
+				corev1.TLSPrivateKeyKey: triple.EncodePrivateKeyPEM(adoptedTLS.Key),
+			}}
+			tlsSecret.ObjectMeta = expectedSecret.ObjectMeta
+			Eventually(func() error {
+				return cli.Create(context.TODO(), tlsSecret.DeepCopy())
+			}, 10*time.Second, time.Second).Should(Succeed(), "should success creating external TLS secret")
+
+			webhookConfiguration := getWebhookConfiguration()
+			webhookConfiguration.Webhooks[0].ClientConfig.CABundle = triple.EncodeCertPEM(adoptedCA.Cert)
+			updateWebhookConfiguration(webhookConfiguration)
+
+			mgr.adoptExistingSecrets = true
+			mgr.adoptCASecret = &types.NamespacedName{Namespace: adoptedCASecret.Namespace, Name: adoptedCASecret.Name}
+		})
+		AfterEach(func() {
+			Expect(cli.Delete(context.TODO(), &adoptedCASecret)).To(Succeed(), "should success deleting external CA secret")
+		})
+		It("should import the CA and keep the TLS secret", func() {
+			_, err := mgr.Reconcile(context.Background(), reconcile.Request{})
+			Expect(err).To(Succeed(), "should success reconciling")
+
+			tls := getTLS()
+			serviceCerts, err := triple.ParseCertsPEM(tls.serviceCertificate)
+			Expect(err).To(Succeed(), "should success parsing service certificate")
+			Expect(serviceCerts[0].Equal(adoptedTLS.Cert)).To(BeTrue(), "should keep the external TLS certificate")
+			caCerts, err := triple.ParseCertsPEM(tls.caCertificate)
+			Expect(err).To(Succeed(), "should success parsing CA certificate")
+			Expect(caCerts[0].Equal(adoptedCA.Cert)).To(BeTrue(), "should import the external CA")
+			Expect(tls.serviceSecretAnnotations).To(HaveKey(secretManagedAnnotatoinKey), "should adopt the TLS secret")
+		})
+	})
+
+	Context("when ReadyzCheck is called", func() {
+		It("should fail until the certificates are reconciled", func() {
+			request := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			Expect(mgr.ReadyzCheck(request)).ToNot(Succeed(), "should not be ready before reconciling")
+
+			_, err := mgr.Reconcile(context.Background(), reconcile.Request{})
+			Expect(err).To(Succeed(), "should success reconciling")
+			Expect(mgr.ReadyzCheck(request)).To(Succeed(), "should be ready after reconciling")
+		})
+	})
+
+	Context("when NeedLeaderElection is set", func() {
+		It("should override the certificate controller leader election", func() {
+			Expect(mgr.NeedLeaderElection()).To(BeTrue(), "should need leader election by default")
+
+			needLeaderElection := false
+			nonLeaderMgr, err := NewManager(cli, &Options{
+				WebhookName:        expectedMutatingWebhookConfiguration.Name,
+				WebhookType:        MutatingWebhook,
+				Namespace:          expectedNamespace.Name,
+				NeedLeaderElection: &needLeaderElection,
+			})
+			Expect(err).To(Succeed(), "should succeed constructing certificate manager")
+			Expect(nonLeaderMgr.NeedLeaderElection()).To(BeFalse(), "should not need leader election")
+
+			runnable, ok := nonLeaderMgr.withLeaderElection(manager.RunnableFunc(nil)).(manager.LeaderElectionRunnable)
+			Expect(ok).To(BeTrue(), "should implement LeaderElectionRunnable")
+			Expect(runnable.NeedLeaderElection()).To(BeFalse(), "should not need leader election")
+		})
+	})
+
+	Context("when a managed secret event is filtered", func() {
+		secretWithOwner := func(owner string) *corev1.Secret {
+			secret := expectedSecret.DeepCopy()
+			secret.Annotations = map[string]string{secretManagedAnnotatoinKey: owner}
+			return secret
+		}
+		It("should only match the secrets owned by this webhook", func() {
+			Expect(mgr.isGeneratedSecret(secretWithOwner(mgr.secretOwner()))).To(BeTrue(), "should match own secret")
+			Expect(mgr.isGeneratedSecret(secretWithOwner("Validating/other"))).To(BeFalse(), "should not match other webhook secret")
+			Expect(mgr.isGeneratedSecret(secretWithOwner(""))).To(BeTrue(), "should fall back to the webhook services")
+		})
+	})
+
 	Context("when integrated into a controller-runtime manager and started", func() {
 		var (
 			crManager manager.Manager
@@ -0,0 +1,199 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate/triple"
+)
+
+// ChainArchive is the serialized certificate chain of a webhook
+// configuration, all the certificates and keys are PEM encoded so the
+// archive can be inspected offline.
+type ChainArchive struct {
+	WebhookName string           `json:"webhookName"`
+	WebhookType WebhookType      `json:"webhookType"`
+	CACert      string           `json:"caCert"`
+	CAKey       string           `json:"caKey"`
+	CABundle    string           `json:"caBundle"`
+	Services    []ServiceArchive `json:"services,omitempty"`
+}
+
+// ServiceArchive contains the TLS secret data for one of the services
+// referenced by the webhook configuration.
+type ServiceArchive struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	TLSCert   string `json:"tlsCert"`
+	TLSKey    string `json:"tlsKey"`
+}
+
+// Export serializes the CA, CABundle and service secrets as a JSON
+// ChainArchive, useful for backup and cluster migration.
+func (m *Manager) Export(ctx context.Context) ([]byte, error) {
+	caKeyPair, err := m.getCAKeyPair(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting CA key pair to export")
+	}
+
+	caBundle, err := m.CABundleWithContext(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting CABundle to export")
+	}
+
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting webhook configuration to export")
+	}
+
+	services, err := m.getServicesFromConfiguration(webhookConf)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting services to export")
+	}
+
+	caKeyPEM, err := m.encodePrivateKeyPEM(caKeyPair.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed encoding CA private key to export")
+	}
+
+	archive := ChainArchive{
+		WebhookName: m.webhookName,
+		WebhookType: m.webhookType,
+		CACert:      string(triple.EncodeCertPEM(caKeyPair.Cert)),
+		CAKey:       string(caKeyPEM),
+		CABundle:    string(caBundle),
+	}
+
+	for service := range services {
+		secret := corev1.Secret{}
+		err = m.get(ctx, service, &secret)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed reading TLS secret %s to export", service)
+		}
+		archive.Services = append(archive.Services, ServiceArchive{
+			Namespace: service.Namespace,
+			Name:      service.Name,
+			TLSCert:   string(secret.Data[corev1.TLSCertKey]),
+			TLSKey:    string(secret.Data[corev1.TLSPrivateKeyKey]),
+		})
+	}
+
+	// Keep the archive stable between exports
+	sort.Slice(archive.Services, func(i, j int) bool {
+		if archive.Services[i].Namespace != archive.Services[j].Namespace {
+			return archive.Services[i].Namespace < archive.Services[j].Namespace
+		}
+		return archive.Services[i].Name < archive.Services[j].Name
+	})
+
+	return json.MarshalIndent(&archive, "", "  ")
+}
+
+// Import restores a ChainArchive generated by Export, it overwrites the
+// CABundle, CA secret and service secrets, next Reconcile will calculate
+// the rotation deadlines from the imported certificates.
+func (m *Manager) Import(ctx context.Context, data []byte) error {
+	archive := ChainArchive{}
+	err := json.Unmarshal(data, &archive)
+	if err != nil {
+		return errors.Wrap(err, "failed unmarshaling chain archive")
+	}
+
+	if archive.WebhookName != m.webhookName || archive.WebhookType != m.webhookType {
+		return errors.Errorf("chain archive is for %s webhook %s, expected %s webhook %s",
+			archive.WebhookType, archive.WebhookName, m.webhookType, m.webhookName)
+	}
+
+	caKeyPair, err := parseArchiveKeyPair(archive.CACert, archive.CAKey)
+	if err != nil {
+		return errors.Wrap(err, "failed parsing archive CA key pair")
+	}
+
+	for _, service := range archive.Services {
+		_, err = parseArchiveKeyPair(service.TLSCert, service.TLSKey)
+		if err != nil {
+			return errors.Wrapf(err, "failed parsing archive TLS key pair for service %s/%s", service.Namespace, service.Name)
+		}
+	}
+
+	caBundle := []byte(archive.CABundle)
+	err = m.updateWebhookCABundleWithFunc(ctx, func([]byte) ([]byte, error) {
+		return caBundle, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed importing CABundle")
+	}
+
+	err = m.applyCASecret(ctx, caKeyPair)
+	if err != nil {
+		return errors.Wrap(err, "failed importing CA secret")
+	}
+
+	err = m.applyCATrustStore(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed importing CA trust store")
+	}
+
+	for _, service := range archive.Services {
+		serviceKey := types.NamespacedName{Namespace: service.Namespace, Name: service.Name}
+		tlsCert, tlsKey := []byte(service.TLSCert), []byte(service.TLSKey)
+		err = m.applySecret(ctx, serviceKey, corev1.SecretTypeTLS, nil,
+			func(secret *corev1.Secret, _ *triple.KeyPair) (*corev1.Secret, error) {
+				setAnnotation(secret)
+				secret.Data = map[string][]byte{
+					corev1.TLSCertKey:       tlsCert,
+					corev1.TLSPrivateKeyKey: tlsKey,
+					CACertKey:               caBundle,
+				}
+				return secret, nil
+			})
+		if err != nil {
+			return errors.Wrapf(err, "failed importing TLS secret %s", serviceKey)
+		}
+	}
+
+	// Force deadlines calculation from the imported certificates
+	m.lastRotateDeadline = nil
+	m.lastRotateDeadlineForServices = nil
+	m.audit(ctx, AuditActionImport, "certificate chain imported", m.chainObjects(ctx)...)
+	return nil
+}
+
+func parseArchiveKeyPair(certPEM, keyPEM string) (*triple.KeyPair, error) {
+	certs, err := triple.ParseCertsPEM([]byte(certPEM))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed parsing certificate")
+	}
+	key, err := triple.ParsePrivateKeyPEM([]byte(keyPEM))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed parsing private key")
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not RSA")
+	}
+	return &triple.KeyPair{Key: rsaKey, Cert: getFirstCert(certs)}, nil
+}
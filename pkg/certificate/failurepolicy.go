@@ -0,0 +1,174 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// FailurePoliciesAnnotationKey contains the JSON list of the webhooks
+// failurePolicy values before Options.RelaxFailurePolicyOnRotation set
+// them to Ignore, an empty value means it was not set. It's kept until
+// they are restored so they are restored even if the process dies
+// during the rotation.
+const FailurePoliciesAnnotationKey = "kubevirt.io/kube-admission-webhook-failure-policies"
+
+// withRelaxedFailurePolicy calls fn with the webhooks failurePolicy set to
+// Ignore, if Options.RelaxFailurePolicyOnRotation is set, and restores
+// them after it.
+func (m *Manager) withRelaxedFailurePolicy(ctx context.Context, fn func() error) error {
+	if !m.relaxFailurePolicyOnRotation {
+		return fn()
+	}
+
+	err := m.relaxFailurePolicy(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed relaxing webhooks failure policy")
+	}
+
+	fnErr := fn()
+
+	err = m.restoreFailurePolicy(ctx)
+	if err != nil {
+		if fnErr != nil {
+			return errors.Wrapf(fnErr, "failed restoring webhooks failure policy: %v, after error", err)
+		}
+		return errors.Wrap(err, "failed restoring webhooks failure policy")
+	}
+	return fnErr
+}
+
+// relaxFailurePolicy stores the webhooks failurePolicy at
+// FailurePoliciesAnnotationKey and sets them to Ignore, if the annotation
+// is already there they were not restored yet so it's kept.
+func (m *Manager) relaxFailurePolicy(ctx context.Context) error {
+	m.log.Info("Relaxing webhooks failure policy")
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		webhookConf, err := m.readyWebhookConfiguration(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed reading webhook configuration")
+		}
+
+		annotations := webhookConf.GetAnnotations()
+		if _, found := annotations[FailurePoliciesAnnotationKey]; !found {
+			var policiesJSON []byte
+			policiesJSON, err = json.Marshal(m.failurePolicies(webhookConf))
+			if err != nil {
+				return errors.Wrap(err, "failed marshaling failure policies")
+			}
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+			annotations[FailurePoliciesAnnotationKey] = string(policiesJSON)
+			webhookConf.SetAnnotations(annotations)
+		}
+
+		ignore := admissionregistrationv1.Ignore
+		policies := m.failurePolicies(webhookConf)
+		for i := range policies {
+			policies[i] = ignore
+		}
+		m.setFailurePolicies(webhookConf, policies)
+		return m.client.Update(ctx, webhookConf)
+	})
+}
+
+// restoreFailurePolicy sets back the webhooks failurePolicy stored at
+// FailurePoliciesAnnotationKey and removes it, it does nothing if the
+// annotation is not there.
+func (m *Manager) restoreFailurePolicy(ctx context.Context) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		webhookConf, err := m.readyWebhookConfiguration(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed reading webhook configuration")
+		}
+
+		annotations := webhookConf.GetAnnotations()
+		policiesJSON, found := annotations[FailurePoliciesAnnotationKey]
+		if !found {
+			return nil
+		}
+
+		m.log.Info("Restoring webhooks failure policy")
+		policies := []admissionregistrationv1.FailurePolicyType{}
+		err = json.Unmarshal([]byte(policiesJSON), &policies)
+		if err != nil {
+			m.log.Info(fmt.Sprintf("Not restoring bad failure policies annotation: %v", err))
+		} else if len(policies) != len(m.failurePolicies(webhookConf)) {
+			m.log.Info("Not restoring failure policies, webhook configuration changed")
+		} else {
+			m.setFailurePolicies(webhookConf, policies)
+		}
+		delete(annotations, FailurePoliciesAnnotationKey)
+		webhookConf.SetAnnotations(annotations)
+		return m.client.Update(ctx, webhookConf)
+	})
+}
+
+// failurePolicies returns the failurePolicy of every webhook at the
+// configuration, empty if it's not set.
+func (m *Manager) failurePolicies(webhook client.Object) []admissionregistrationv1.FailurePolicyType {
+	policies := []admissionregistrationv1.FailurePolicyType{}
+	appendPolicy := func(policy *admissionregistrationv1.FailurePolicyType) {
+		if policy == nil {
+			policies = append(policies, "")
+		} else {
+			policies = append(policies, *policy)
+		}
+	}
+	if m.webhookType == MutatingWebhook {
+		for _, mutatingWebhook := range mutatingWebhookConfig(webhook).Webhooks {
+			appendPolicy(mutatingWebhook.FailurePolicy)
+		}
+	} else if m.webhookType == ValidatingWebhook {
+		for _, validatingWebhook := range validatingWebhookConfig(webhook).Webhooks {
+			appendPolicy(validatingWebhook.FailurePolicy)
+		}
+	}
+	return policies
+}
+
+// setFailurePolicies sets the failurePolicy of every webhook at the
+// configuration from policies, empty values unset it.
+func (m *Manager) setFailurePolicies(webhook client.Object, policies []admissionregistrationv1.FailurePolicyType) {
+	policyAt := func(i int) *admissionregistrationv1.FailurePolicyType {
+		if policies[i] == "" {
+			return nil
+		}
+		policy := policies[i]
+		return &policy
+	}
+	if m.webhookType == MutatingWebhook {
+		webhooks := mutatingWebhookConfig(webhook).Webhooks
+		for i := range webhooks {
+			webhooks[i].FailurePolicy = policyAt(i)
+		}
+	} else if m.webhookType == ValidatingWebhook {
+		webhooks := validatingWebhookConfig(webhook).Webhooks
+		for i := range webhooks {
+			webhooks[i].FailurePolicy = policyAt(i)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"fmt"
+	"sort"
+)
+
+// FeatureGate names a Manager behavior that can be enabled or disabled
+// with Options.FeatureGates, so experimental behaviors ship disabled and
+// are enabled per deployment before becoming the default.
+type FeatureGate string
+
+const (
+	// FeatureGatePreflightOnStart runs Preflight before starting the
+	// certificate controller, so it fails to start if the webhook
+	// configuration services or RBAC are missing, it's disabled by default
+	FeatureGatePreflightOnStart FeatureGate = "PreflightOnStart"
+)
+
+// defaultFeatureGates contains the known feature gates with their default
+var defaultFeatureGates = map[FeatureGate]bool{
+	FeatureGatePreflightOnStart: false,
+}
+
+// featureGates returns all the known feature gates with the defaults
+// overridden by gates.
+func featureGates(gates map[FeatureGate]bool) map[FeatureGate]bool {
+	enabled := map[FeatureGate]bool{}
+	for gate, enable := range defaultFeatureGates {
+		enabled[gate] = enable
+	}
+	for gate, enable := range gates {
+		enabled[gate] = enable
+	}
+	return enabled
+}
+
+func validateFeatureGates(gates map[FeatureGate]bool) error {
+	unknown := []string{}
+	for gate := range gates {
+		if _, found := defaultFeatureGates[gate]; !found {
+			unknown = append(unknown, string(gate))
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown 'FeatureGates' %v", unknown)
+	}
+	return nil
+}
+
+func (m *Manager) featureEnabled(gate FeatureGate) bool {
+	return m.featureGates[gate]
+}
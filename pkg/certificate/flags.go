@@ -0,0 +1,300 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// EnvPrefix prefixes the environment variables read by SetFlagsFromEnv,
+// the flag "ca-rotate-interval" is read from
+// KUBE_ADMISSION_WEBHOOK_CA_ROTATE_INTERVAL.
+const EnvPrefix = "KUBE_ADMISSION_WEBHOOK_"
+
+// AddFlags registers flags for the Options that can be serialized, the
+// same ones as Config, the current values are the flags defaults.
+func (o *Options) AddFlags(fs *pflag.FlagSet) {
+	o.addWebhookFlags(fs)
+	o.addRotationFlags(fs)
+	o.addSecretsFlags(fs)
+	o.addControllerFlags(fs)
+}
+
+func (o *Options) addWebhookFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.WebhookName, "webhook-name", o.WebhookName, "Mutating or Validating webhook configuration name")
+	fs.StringVar((*string)(&o.WebhookType), "webhook-type", string(o.WebhookType), "webhook configuration type, Mutating or Validating")
+	fs.StringVar(&o.Namespace, "namespace", o.Namespace, "namespace of the CA secret and the URL webhooks secrets")
+	fs.StringVar(&o.CANamespace, "ca-namespace", o.CANamespace, "namespace of the CA secret, if not set --namespace is used")
+	fs.BoolVar(&o.RelaxFailurePolicyOnRotation, "relax-failure-policy-on-rotation", o.RelaxFailurePolicyOnRotation,
+		"set the webhooks failurePolicy to Ignore while rotating")
+	fs.StringVar((*string)(&o.OLMPolicy), "olm-policy", string(o.OLMPolicy), "policy for OLM owned webhooks, TakeOver, Skip or VerifyOnly")
+	fs.Var(optionalBoolValue(&o.ManageCABundle), "manage-ca-bundle", "write the CA to the webhook configuration CABundle")
+	fs.Var(namespacedNameValue(&o.CABundleSource), "ca-bundle-source", "namespace/name of the secret the CABundle is read from")
+	fs.BoolVar(&o.MonitorOnly, "monitor-only", o.MonitorOnly, "only verify the certificates and expose their status")
+	fs.IntVar(&o.NotificationThreshold, "notification-threshold", o.NotificationThreshold, "consecutive failures to notify")
+	fs.Var(workloadsValue(&o.RestartWorkloads), "restart-workloads", "kind/namespace/name of the workloads to restart after rotating")
+	fs.BoolVar(&o.LabelWebhookConfiguration, "label-webhook-configuration", o.LabelWebhookConfiguration,
+		"add the extra labels, annotations and managed label to the webhook configuration")
+	fs.StringToStringVar(&o.ExtraLabels, "extra-labels", o.ExtraLabels, "labels added to the managed objects")
+	fs.StringToStringVar(&o.ExtraAnnotations, "extra-annotations", o.ExtraAnnotations, "annotations added to the managed objects")
+	fs.Var(featureGatesValue(&o.FeatureGates), "feature-gates", "comma separated Gate=true|false pairs")
+	fs.Var(serviceSelectorValue(&o.Service), "service-selector", "key=value,... selector of the Services created for the webhook")
+	fs.Var(serviceTargetPortValue(&o.Service), "service-target-port", "webhook server port of the Services created for the webhook")
+}
+
+func (o *Options) addRotationFlags(fs *pflag.FlagSet) {
+	fs.DurationVar(&o.CARotateInterval, "ca-rotate-interval", o.CARotateInterval, "duration of the CA certificate")
+	fs.DurationVar(&o.CAOverlapInterval, "ca-overlap-interval", o.CAOverlapInterval, "duration of the previous CAs at the CABundle")
+	fs.DurationVar(&o.CertRotateInterval, "cert-rotate-interval", o.CertRotateInterval, "duration of the services certificates")
+	fs.DurationVar(&o.CertOverlapInterval, "cert-overlap-interval", o.CertOverlapInterval,
+		"duration of the previous services certificates at the secrets")
+	fs.DurationVar(&o.CARenewBefore, "ca-renew-before", o.CARenewBefore, "rotate the CA when less than it remains to expire")
+	fs.DurationVar(&o.CertRenewBefore, "cert-renew-before", o.CertRenewBefore,
+		"rotate the services certificates when less than it remains to expire")
+	fs.Float64Var(&o.RenewFraction, "renew-fraction", o.RenewFraction, "fraction of the certificates duration to rotate them at")
+	fs.BoolVar(&o.LockCARotation, "lock-ca-rotation", o.LockCARotation, "serialize the CA rotations with a Lease")
+	fs.IntVar(&o.CleanupAcknowledgements, "cleanup-acknowledgements", o.CleanupAcknowledgements,
+		"acknowledgements needed to clean up the CABundle")
+	fs.BoolVar(&o.HardCutover, "hard-cutover", o.HardCutover, "replace the certificates without overlap")
+	fs.BoolVar(&o.ProbeServiceTLS, "probe-service-tls", o.ProbeServiceTLS, "dial the services before adding a new CA to the CABundle")
+	fs.BoolVar(&o.ProbeBeforeCABundleCleanup, "probe-before-ca-bundle-cleanup", o.ProbeBeforeCABundleCleanup,
+		"dial the services before cleaning up the CABundle")
+	fs.BoolVar(&o.CanaryRotation, "canary-rotation", o.CanaryRotation, "rotate and verify the first service before the rest")
+	fs.IntVar(&o.RotationHistoryLimit, "rotation-history-limit", o.RotationHistoryLimit, "rotations recorded at the secrets")
+}
+
+func (o *Options) addSecretsFlags(fs *pflag.FlagSet) {
+	fs.Var(namespacedNameValue(&o.SharedCASecret), "shared-ca-secret", "namespace/name of a CA secret shared with other webhooks")
+	fs.StringVar((*string)(&o.TLSCertChain), "tls-cert-chain", string(o.TLSCertChain), "certificates at tls.crt, Overlap or Leaf")
+	fs.BoolVar(&o.IncludeCAInTLSCert, "include-ca-in-tls-cert", o.IncludeCAInTLSCert, "append the CA to tls.crt")
+	fs.BoolVar(&o.SANOnlyCertificates, "san-only-certificates", o.SANOnlyCertificates, "issue certificates without CommonName")
+	fs.StringVar((*string)(&o.PrivateKeyEncoding), "private-key-encoding", string(o.PrivateKeyEncoding),
+		"PEM encoding of the private keys, PKCS1 or PKCS8")
+	fs.BoolVar(&o.CACertInConfigMap, "ca-cert-in-configmap", o.CACertInConfigMap, "store the CA certificate at a ConfigMap")
+	fs.BoolVar(&o.CATrustStore, "ca-trust-store", o.CATrustStore, "render the CABundle as a JKS trust store")
+	fs.StringVar(&o.CATrustStorePassword, "ca-trust-store-password", o.CATrustStorePassword, "JKS trust store password")
+	fs.BoolVar(&o.CertManagerAnnotations, "cert-manager-annotations", o.CertManagerAnnotations,
+		"add the cert-manager.io annotations to the secrets")
+	fs.BoolVar(&o.AdoptExistingSecrets, "adopt-existing-secrets", o.AdoptExistingSecrets, "take ownership of valid existing secrets")
+	fs.Var(namespacedNameValue(&o.AdoptCASecret), "adopt-ca-secret", "namespace/name of the CA secret that issued the secrets to adopt")
+	fs.BoolVar(&o.ServiceIPSANs, "service-ip-sans", o.ServiceIPSANs, "add the services IPs as certificates IP SANs")
+}
+
+func (o *Options) addControllerFlags(fs *pflag.FlagSet) {
+	fs.Var(optionalBoolValue(&o.NeedLeaderElection), "need-leader-election", "run the certificate controller only at the leader")
+	fs.IntVar(&o.MaxConcurrentReconciles, "max-concurrent-reconciles", o.MaxConcurrentReconciles, "certificate controller workers")
+	fs.DurationVar(&o.CacheSyncTimeout, "cache-sync-timeout", o.CacheSyncTimeout, "time limit to wait for the caches to sync")
+	fs.DurationVar(&o.SelfTestInterval, "self-test-interval", o.SelfTestInterval, "interval of the webhooks self test")
+	fs.BoolVar(&o.WaitForWebhookConfiguration, "wait-for-webhook-configuration", o.WaitForWebhookConfiguration,
+		"start the certificate controller once the webhook configuration exists")
+}
+
+// SetFlagsFromEnv sets the flags not passed at the command line from the
+// EnvPrefix environment variables, it has to be called after fs.Parse.
+func SetFlagsFromEnv(fs *pflag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed {
+			return
+		}
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(flag.Name, "-", "_"))
+		value, found := os.LookupEnv(name)
+		if !found {
+			return
+		}
+		if setErr := fs.Set(flag.Name, value); setErr != nil {
+			err = errors.Wrapf(setErr, "failed setting flag %s from %s", flag.Name, name)
+		}
+	})
+	return err
+}
+
+// funcValue is a pflag.Value for the Options fields without a pflag type
+type funcValue struct {
+	typ string
+	get func() string
+	set func(string) error
+}
+
+func (v *funcValue) String() string     { return v.get() }
+func (v *funcValue) Set(s string) error { return v.set(s) }
+func (v *funcValue) Type() string       { return v.typ }
+
+func optionalBoolValue(p **bool) pflag.Value {
+	return &funcValue{
+		typ: "bool",
+		get: func() string {
+			if *p == nil {
+				return ""
+			}
+			return strconv.FormatBool(**p)
+		},
+		set: func(s string) error {
+			value, err := strconv.ParseBool(s)
+			if err != nil {
+				return err
+			}
+			*p = &value
+			return nil
+		},
+	}
+}
+
+func namespacedNameValue(p **types.NamespacedName) pflag.Value {
+	return &funcValue{
+		typ: "namespace/name",
+		get: func() string {
+			if *p == nil {
+				return ""
+			}
+			return (*p).String()
+		},
+		set: func(s string) error {
+			fields := strings.Split(s, "/")
+			if len(fields) != 2 {
+				return fmt.Errorf("expected namespace/name, got %q", s)
+			}
+			*p = &types.NamespacedName{Namespace: fields[0], Name: fields[1]}
+			return nil
+		},
+	}
+}
+
+// workloadsValue replaces the default workloads at the first Set and
+// appends at the next ones, like the pflag slices
+func workloadsValue(p *[]Workload) pflag.Value {
+	changed := false
+	return &funcValue{
+		typ: "kind/namespace/name,...",
+		get: func() string {
+			workloads := []string{}
+			for _, workload := range *p {
+				workloads = append(workloads, workload.String())
+			}
+			return strings.Join(workloads, ",")
+		},
+		set: func(s string) error {
+			if !changed {
+				*p = nil
+				changed = true
+			}
+			for _, workload := range strings.Split(s, ",") {
+				fields := strings.Split(workload, "/")
+				if len(fields) != 3 {
+					return fmt.Errorf("expected kind/namespace/name, got %q", workload)
+				}
+				*p = append(*p, Workload{Kind: WorkloadKind(fields[0]), Namespace: fields[1], Name: fields[2]})
+			}
+			return nil
+		},
+	}
+}
+
+func featureGatesValue(p *map[FeatureGate]bool) pflag.Value {
+	return &funcValue{
+		typ: "gate=bool,...",
+		get: func() string {
+			gates := []string{}
+			for gate, enabled := range *p {
+				gates = append(gates, fmt.Sprintf("%s=%t", gate, enabled))
+			}
+			sort.Strings(gates)
+			return strings.Join(gates, ",")
+		},
+		set: func(s string) error {
+			if *p == nil {
+				*p = map[FeatureGate]bool{}
+			}
+			for _, gate := range strings.Split(s, ",") {
+				fields := strings.SplitN(gate, "=", 2)
+				if len(fields) != 2 {
+					return fmt.Errorf("expected gate=bool, got %q", gate)
+				}
+				enabled, err := strconv.ParseBool(fields[1])
+				if err != nil {
+					return errors.Wrapf(err, "failed parsing feature gate %s", fields[0])
+				}
+				(*p)[FeatureGate(fields[0])] = enabled
+			}
+			return nil
+		},
+	}
+}
+
+func serviceSelectorValue(p **ServiceTemplate) pflag.Value {
+	return &funcValue{
+		typ: "key=value,...",
+		get: func() string {
+			if *p == nil {
+				return ""
+			}
+			selector := []string{}
+			for key, value := range (*p).Selector {
+				selector = append(selector, fmt.Sprintf("%s=%s", key, value))
+			}
+			sort.Strings(selector)
+			return strings.Join(selector, ",")
+		},
+		set: func(s string) error {
+			selector := map[string]string{}
+			for _, label := range strings.Split(s, ",") {
+				fields := strings.SplitN(label, "=", 2)
+				if len(fields) != 2 {
+					return fmt.Errorf("expected key=value, got %q", label)
+				}
+				selector[fields[0]] = fields[1]
+			}
+			if *p == nil {
+				*p = &ServiceTemplate{}
+			}
+			(*p).Selector = selector
+			return nil
+		},
+	}
+}
+
+func serviceTargetPortValue(p **ServiceTemplate) pflag.Value {
+	return &funcValue{
+		typ: "int32",
+		get: func() string {
+			if *p == nil {
+				return ""
+			}
+			return strconv.Itoa(int((*p).TargetPort))
+		},
+		set: func(s string) error {
+			targetPort, err := strconv.ParseInt(s, 10, 32)
+			if err != nil {
+				return err
+			}
+			if *p == nil {
+				*p = &ServiceTemplate{}
+			}
+			(*p).TargetPort = int32(targetPort)
+			return nil
+		},
+	}
+}
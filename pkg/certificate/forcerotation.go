@@ -0,0 +1,85 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/client-go/util/retry"
+)
+
+// ForceRotationAnnotationKey at the webhook configuration makes the
+// Manager rotate the CA and services certificates at the next Reconcile,
+// the value is the reason audited for it. The Manager removes the
+// annotation after the rotation.
+const ForceRotationAnnotationKey = "kubevirt.io/kube-admission-webhook-force-rotation"
+
+// rotateIfForced rotates all the certificates if the webhook
+// configuration has the ForceRotationAnnotationKey and removes it.
+func (m *Manager) rotateIfForced(ctx context.Context) error {
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed reading webhook configuration")
+	}
+
+	reason, found := webhookConf.GetAnnotations()[ForceRotationAnnotationKey]
+	if !found {
+		return nil
+	}
+	if reason == "" {
+		reason = "forced rotation requested"
+	}
+
+	// Go through the same CA rotation Lease as the scheduled rotation, so
+	// only one of the replicas sharing the CA rotates it, the others keep
+	// the annotation and retry until the holder has removed it.
+	if m.lockCARotation || m.sharedCASecret != nil {
+		acquired, err := m.acquireCARotationLease(ctx)
+		if err != nil {
+			return err
+		}
+		if !acquired {
+			return errors.Errorf("forced rotation is waiting for CA rotation Lease %s held by other Manager", m.caRotationLeaseKey())
+		}
+	}
+
+	m.log.Info("Forcing certificates rotation", "reason", reason)
+	err = m.rotateWithRollback(ctx, (*Manager).rotateAll)
+	if err != nil {
+		return errors.Wrap(err, "failed forcing rotation of all certs")
+	}
+	m.audit(ctx, AuditActionRotateAll, reason, m.chainObjects(ctx)...)
+	m.restartWorkloads(ctx)
+	m.nextRotationDeadlineForCA(ctx)
+	m.nextRotationDeadlineForServices(ctx)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		webhookConf, err = m.readyWebhookConfiguration(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed reading webhook configuration")
+		}
+		annotations := webhookConf.GetAnnotations()
+		if _, found = annotations[ForceRotationAnnotationKey]; !found {
+			return nil
+		}
+		delete(annotations, ForceRotationAnnotationKey)
+		webhookConf.SetAnnotations(annotations)
+		return m.client.Update(ctx, webhookConf)
+	})
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// RotationHistoryAnnotationKey contains the JSON list of the last
+// RotationRecords of a secret, the newest is the last one.
+const RotationHistoryAnnotationKey = "kubevirt.io/kube-admission-webhook-rotation-history"
+
+// RotationRecord describes a certificate written to a secret by a rotation
+type RotationRecord struct {
+	// Time is when the certificate was written
+	Time time.Time `json:"time"`
+
+	// Fingerprint is the hex encoded SHA-256 of the certificate DER
+	Fingerprint string `json:"fingerprint"`
+
+	// NotAfter is the certificate expiration time
+	NotAfter time.Time `json:"notAfter"`
+
+	// Serial is the hex encoded certificate serial number
+	Serial string `json:"serial,omitempty"`
+}
+
+// RotationHistory returns the rotations recorded at secret, the newest is
+// the last one.
+func RotationHistory(secret *corev1.Secret) ([]RotationRecord, error) {
+	history := []RotationRecord{}
+	historyJSON, found := secret.Annotations[RotationHistoryAnnotationKey]
+	if !found {
+		return history, nil
+	}
+	err := json.Unmarshal([]byte(historyJSON), &history)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed parsing rotation history of secret %s/%s", secret.Namespace, secret.Name)
+	}
+	return history, nil
+}
+
+// CertificateFingerprint returns the hex encoded SHA-256 of the certificate
+// DER, it's the fingerprint stored at RotationRecord
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// CertificateSerial returns the hex encoded certificate serial number, as
+// shown by openssl without colons, to correlate it with TLS handshakes and
+// audit logs
+func CertificateSerial(cert *x509.Certificate) string {
+	if cert.SerialNumber == nil {
+		return ""
+	}
+	return fmt.Sprintf("%X", cert.SerialNumber)
+}
+
+// recordRotation appends cert to the secret rotation history keeping only
+// the last Options.RotationHistoryLimit records.
+func (m *Manager) recordRotation(secret *corev1.Secret, cert *x509.Certificate) {
+	if m.rotationHistoryLimit == 0 {
+		return
+	}
+	history, err := RotationHistory(secret)
+	if err != nil {
+		m.log.Info(fmt.Sprintf("Resetting rotation history: %v", err))
+		history = []RotationRecord{}
+	}
+	history = append(history, RotationRecord{
+		Time:        m.now().UTC(),
+		Fingerprint: CertificateFingerprint(cert),
+		NotAfter:    cert.NotAfter.UTC(),
+		Serial:      CertificateSerial(cert),
+	})
+	if len(history) > m.rotationHistoryLimit {
+		history = history[len(history)-m.rotationHistoryLimit:]
+	}
+	historyJSON, err := json.Marshal(history)
+	if err != nil {
+		m.log.Info(fmt.Sprintf("Not recording rotation history: %v", err))
+		return
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[RotationHistoryAnnotationKey] = string(historyJSON)
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"crypto/x509"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate/triple"
+)
+
+// ChainInspection is a read only snapshot of the certificate chain of a
+// webhook configuration, it contains no private keys.
+type ChainInspection struct {
+	WebhookName string              `json:"webhookName"`
+	WebhookType WebhookType         `json:"webhookType"`
+	CASecret    string              `json:"caSecret"`
+	CABundle    []CertificateInfo   `json:"caBundle"`
+	Services    []ServiceInspection `json:"services,omitempty"`
+}
+
+// ServiceInspection contains the certificates at the TLS secret of one of
+// the services referenced by the webhook configuration.
+type ServiceInspection struct {
+	Namespace    string            `json:"namespace"`
+	Name         string            `json:"name"`
+	Certificates []CertificateInfo `json:"certificates"`
+}
+
+// CertificateInfo describes a certificate, the first one at a chain is the
+// last prepended.
+type CertificateInfo struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	DNSNames    []string  `json:"dnsNames,omitempty"`
+	IPAddresses []string  `json:"ipAddresses,omitempty"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	Fingerprint string    `json:"fingerprint"`
+	Serial      string    `json:"serial"`
+}
+
+// InspectChain returns a ChainInspection of the CABundle and services
+// certificates, it only reads them so it can be called from status
+// endpoints and CLIs while the Manager is running without blocking the
+// rotations.
+func (m *Manager) InspectChain(ctx context.Context) (*ChainInspection, error) {
+	// Do not wait for the webhook configuration to be deployed like
+	// Reconcile, the callers expect an answer, or NotFound, right away
+	webhookConf, err := m.emptyWebhookConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	err = m.get(ctx, types.NamespacedName{Name: m.webhookName}, webhookConf)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting webhook configuration to inspect")
+	}
+
+	cas, err := m.inspectedCAs(ctx, webhookConf)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting CA certificates to inspect")
+	}
+
+	services, err := m.getServicesFromConfiguration(webhookConf)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed getting services to inspect")
+	}
+
+	inspection := ChainInspection{
+		WebhookName: m.webhookName,
+		WebhookType: m.webhookType,
+		CASecret:    m.caSecretKey().String(),
+		CABundle:    certificatesInfo(cas),
+	}
+
+	for _, service := range sortedServices(services) {
+		var certs []*x509.Certificate
+		certs, err = m.getTLSCerts(ctx, service)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed getting TLS certificates from service %s to inspect", service)
+		}
+		inspection.Services = append(inspection.Services, ServiceInspection{
+			Namespace:    service.Namespace,
+			Name:         service.Name,
+			Certificates: certificatesInfo(certs),
+		})
+	}
+	return &inspection, nil
+}
+
+// inspectedCAs returns the CABundle certificates from the already read
+// webhookConf, or from the CA secret if Options.ManageCABundle is unset.
+func (m *Manager) inspectedCAs(ctx context.Context, webhookConf client.Object) ([]*x509.Certificate, error) {
+	if !m.manageCABundle {
+		return m.getCACertsFromCABundle(ctx)
+	}
+	clientConfigList := m.clientConfigList(webhookConf)
+	if len(clientConfigList) == 0 || len(clientConfigList[0].CABundle) == 0 {
+		return nil, nil
+	}
+	return triple.ParseCertsPEM(clientConfigList[0].CABundle)
+}
+
+func certificatesInfo(certs []*x509.Certificate) []CertificateInfo {
+	infos := []CertificateInfo{}
+	for _, cert := range certs {
+		info := CertificateInfo{
+			Subject:     cert.Subject.String(),
+			Issuer:      cert.Issuer.String(),
+			DNSNames:    append([]string{}, cert.DNSNames...),
+			NotBefore:   cert.NotBefore.UTC(),
+			NotAfter:    cert.NotAfter.UTC(),
+			Fingerprint: CertificateFingerprint(cert),
+			Serial:      CertificateSerial(cert),
+		}
+		for _, ip := range cert.IPAddresses {
+			info.IPAddresses = append(info.IPAddresses, ip.String())
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate/triple"
+)
+
+// Issuer generates the CA and service certificates/keys, the Manager only
+// schedules the rotation and cleanup, so plugging a different Issuer allows
+// to sign the certificates with other backends (CSR API, Vault, KMS...).
+type Issuer interface {
+	// IssueCA returns a new CA key pair named after the webhook
+	// configuration that expires after duration
+	IssueCA(name string, duration time.Duration) (*triple.KeyPair, error)
+
+	// IssueServiceCert returns a new key pair for the service signed by
+	// the ca key pair, the hostnames are added to the DNS names of the
+	// certificate
+	IssueServiceCert(ca *triple.KeyPair, service types.NamespacedName, hostnames []string,
+		duration time.Duration) (*triple.KeyPair, error)
+}
+
+// ServiceIPsIssuer is an optional Issuer interface to add IP addresses to
+// the service certificates, Options.ServiceIPSANs needs the Issuer to
+// implement it.
+type ServiceIPsIssuer interface {
+	// IssueServiceCertWithIPs is IssueServiceCert with the ips added to
+	// the IP addresses of the certificate
+	IssueServiceCertWithIPs(ca *triple.KeyPair, service types.NamespacedName, hostnames, ips []string,
+		duration time.Duration) (*triple.KeyPair, error)
+}
+
+// tripleIssuer is the default Issuer it generates a self signed CA and
+// service certificates signed by it using the triple package.
+type tripleIssuer struct {
+	// now is the Manager clock, if nil triple.Now is used
+	now func() time.Time
+
+	// sanOnly Options.SANOnlyCertificates
+	sanOnly bool
+}
+
+func (i tripleIssuer) IssueCA(name string, duration time.Duration) (*triple.KeyPair, error) {
+	return triple.NewCAWithClock(name, duration, i.now)
+}
+
+func (i tripleIssuer) IssueServiceCert(ca *triple.KeyPair, service types.NamespacedName, hostnames []string,
+	duration time.Duration) (*triple.KeyPair, error) {
+	return i.IssueServiceCertWithIPs(ca, service, hostnames, nil, duration)
+}
+
+func (i tripleIssuer) IssueServiceCertWithIPs(ca *triple.KeyPair, service types.NamespacedName, hostnames, ips []string,
+	duration time.Duration) (*triple.KeyPair, error) {
+	commonName := service.Name + "." + service.Namespace + ".pod.cluster.local"
+	if i.sanOnly {
+		commonName = ""
+	}
+	return triple.NewServerKeyPairWithClock(
+		ca,
+		commonName,
+		service.Name,
+		service.Namespace,
+		"cluster.local",
+		ips,
+		hostnames,
+		duration,
+		i.now,
+	)
+}
+
+// issueServiceCert issues the service certificate with the ips if the
+// Issuer implements ServiceIPsIssuer
+func (m *Manager) issueServiceCert(ca *triple.KeyPair, service types.NamespacedName, hostnames, ips []string) (*triple.KeyPair, error) {
+	if ipsIssuer, isIPsIssuer := m.issuer.(ServiceIPsIssuer); isIPsIssuer {
+		return ipsIssuer.IssueServiceCertWithIPs(ca, service, hostnames, ips, m.serviceCertDuration)
+	}
+	return m.issuer.IssueServiceCert(ca, service, hostnames, m.serviceCertDuration)
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"crypto/rsa"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate/triple"
+)
+
+// privateKeyFields are the secrets data keys with private keys
+var privateKeyFields = []string{corev1.TLSPrivateKeyKey, CAPrivateKeyKey}
+
+// PrivateKeyEncoding is the PEM encoding of the private keys stored at the
+// secrets, both are accepted when reading them
+type PrivateKeyEncoding string
+
+const (
+	// PrivateKeyEncodingPKCS1 stores the keys as "RSA PRIVATE KEY" blocks
+	PrivateKeyEncodingPKCS1 PrivateKeyEncoding = "PKCS1"
+
+	// PrivateKeyEncodingPKCS8 stores the keys as "PRIVATE KEY" blocks
+	PrivateKeyEncodingPKCS8 PrivateKeyEncoding = "PKCS8"
+)
+
+// withPrivateKeyEncoding re-encodes the private key written by
+// populateSecretFn following Options.PrivateKeyEncoding.
+func (m *Manager) withPrivateKeyEncoding(
+	populateSecretFn func(*corev1.Secret, *triple.KeyPair) (*corev1.Secret, error),
+) func(*corev1.Secret, *triple.KeyPair) (*corev1.Secret, error) {
+	if m.privateKeyEncoding != PrivateKeyEncodingPKCS8 {
+		return populateSecretFn
+	}
+	return func(secret *corev1.Secret, keyPair *triple.KeyPair) (*corev1.Secret, error) {
+		populatedSecret, err := populateSecretFn(secret, keyPair)
+		if err != nil || keyPair == nil {
+			return populatedSecret, err
+		}
+		keyPEM, err := m.encodePrivateKeyPEM(keyPair.Key)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range privateKeyFields {
+			if _, found := populatedSecret.Data[field]; found {
+				populatedSecret.Data[field] = keyPEM
+			}
+		}
+		return populatedSecret, nil
+	}
+}
+
+// encodePrivateKeyPEM encodes key following Options.PrivateKeyEncoding
+func (m *Manager) encodePrivateKeyPEM(key *rsa.PrivateKey) ([]byte, error) {
+	if m.privateKeyEncoding != PrivateKeyEncodingPKCS8 {
+		return triple.EncodePrivateKeyPEM(key), nil
+	}
+	keyPEM, err := triple.EncodePKCS8PrivateKeyPEM(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed encoding private key as PKCS#8")
+	}
+	return keyPEM, nil
+}
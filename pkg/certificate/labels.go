@@ -0,0 +1,172 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ManagedLabelKey labels the secrets and configmaps created by the Manager
+// so informers can be restricted to them with a label selector.
+const ManagedLabelKey = "kubevirt.io/kube-admission-webhook"
+
+// ManagedSelector selects the secrets and configmaps labeled by the Manager
+func ManagedSelector() labels.Selector {
+	requirement, err := labels.NewRequirement(ManagedLabelKey, selection.Exists, nil)
+	if err != nil {
+		// The requirement is constant so this can only fail on a
+		// programming error
+		panic(err)
+	}
+	return labels.NewSelector().Add(*requirement)
+}
+
+// NewCache builds a controller-runtime cache that only keeps the secrets
+// and configmaps labeled by the Manager, to be used at manager.Options.NewCache
+// in big clusters so the certificate controller informers do not hold every
+// Secret.
+// The secrets that may not be labeled yet, like Options.CABundleSource,
+// Options.AdoptCASecret or the existing secrets checked for collisions and
+// adoption, are read with the controller-runtime manager API reader, the
+// rest of Secret and ConfigMap reads done with the manager client are
+// restricted by it. At the first Reconcile the Manager labels the objects
+// created by older versions, only annotated, and the CABundleSource secret
+// so they are visible and watched.
+func NewCache() cache.NewCacheFunc {
+	selector := cache.ObjectSelector{Label: ManagedSelector()}
+	return cache.BuilderWithOptions(cache.Options{
+		SelectorsByObject: cache.SelectorsByObject{
+			&corev1.Secret{}:    selector,
+			&corev1.ConfigMap{}: selector,
+		},
+	})
+}
+
+func isLabeledResource(object client.Object) bool {
+	_, foundLabel := object.GetLabels()[ManagedLabelKey]
+	return foundLabel
+}
+
+func setManagedLabel(object metav1.Object) {
+	objectLabels := object.GetLabels()
+	if objectLabels == nil {
+		objectLabels = map[string]string{}
+	}
+	objectLabels[ManagedLabelKey] = ""
+	object.SetLabels(objectLabels)
+}
+
+// setExtraMetadata adds Options.ExtraLabels, Options.ExtraAnnotations and
+// the managed label to an object created or updated by the Manager, they
+// are copied so the options maps are never modified.
+func (m *Manager) setExtraMetadata(object metav1.Object) {
+	objectLabels := object.GetLabels()
+	if objectLabels == nil {
+		objectLabels = map[string]string{}
+	}
+	for key, value := range m.extraLabels {
+		objectLabels[key] = value
+	}
+	object.SetLabels(objectLabels)
+	setManagedLabel(object)
+
+	objectAnnotations := object.GetAnnotations()
+	if objectAnnotations == nil {
+		objectAnnotations = map[string]string{}
+	}
+	for key, value := range m.extraAnnotations {
+		objectAnnotations[key] = value
+	}
+	object.SetAnnotations(objectAnnotations)
+}
+
+// labelManagedObjectsOnce runs labelManagedObjects only at the first
+// Reconcile.
+func (m *Manager) labelManagedObjectsOnce(ctx context.Context) error {
+	if m.managedObjectsLabeled {
+		return nil
+	}
+	err := m.labelManagedObjects(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed labeling managed objects")
+	}
+	m.managedObjectsLabeled = true
+	return nil
+}
+
+// labelManagedObjects adds ManagedLabelKey to the secrets and configmaps
+// created by older versions, that only have the managed annotation, and
+// to the Options.CABundleSource secret, so NewCache sees them and the
+// Manager does not try to create them again.
+func (m *Manager) labelManagedObjects(ctx context.Context) error {
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed reading webhook configuration to label managed objects")
+	}
+
+	objects := map[types.NamespacedName]client.Object{m.caSecretKey(): &corev1.Secret{}}
+	for _, clientConfig := range m.clientConfigList(webhookConf) {
+		objects[m.secretKeyForClientConfig(clientConfig)] = &corev1.Secret{}
+	}
+	if m.caBundleSource != nil {
+		objects[*m.caBundleSource] = &corev1.Secret{}
+	}
+	if m.caCertInConfigMap {
+		err = m.labelManagedObject(ctx, m.caConfigMapKey(), &corev1.ConfigMap{})
+		if err != nil {
+			return err
+		}
+	}
+	for key, object := range objects {
+		err = m.labelManagedObject(ctx, key, object)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) labelManagedObject(ctx context.Context, key types.NamespacedName, object client.Object) error {
+	err := m.getUnlabeled(ctx, key, object)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "failed reading %T %s to label it", object, key)
+	}
+	if isLabeledResource(object) || (!isAnnotatedResource(object) && !m.isCABundleSource(object)) {
+		return nil
+	}
+	setManagedLabel(object)
+	err = m.storage.Update(ctx, object)
+	if err != nil {
+		return errors.Wrapf(err, "failed labeling %T %s", object, key)
+	}
+	m.log.Info("Labeled object so NewCache sees it", "key", key)
+	return nil
+}
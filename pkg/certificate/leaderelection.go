@@ -0,0 +1,43 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// leaderElectionRunnable overrides the leader election of runnable with
+// Options.NeedLeaderElection
+type leaderElectionRunnable struct {
+	manager.Runnable
+	needLeaderElection bool
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (r leaderElectionRunnable) NeedLeaderElection() bool {
+	return r.needLeaderElection
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, it
+// returns Options.NeedLeaderElection, true if not set.
+func (m *Manager) NeedLeaderElection() bool {
+	return m.needLeaderElection
+}
+
+func (m *Manager) withLeaderElection(runnable manager.Runnable) manager.Runnable {
+	return leaderElectionRunnable{Runnable: runnable, needLeaderElection: m.needLeaderElection}
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// caRotationLeaseDuration is how long the Manager rotating the CA keeps
+// the Lease, other Managers do not rotate it meanwhile
+const caRotationLeaseDuration = time.Minute
+
+// caRotationLeaseKey is the coordination Lease at the CA secret namespace
+// that has to be held to rotate the CA with Options.LockCARotation or
+// Options.SharedCASecret
+func (m *Manager) caRotationLeaseKey() types.NamespacedName {
+	caSecretKey := m.caSecretKey()
+	return types.NamespacedName{Namespace: caSecretKey.Namespace, Name: caSecretKey.Name + "-rotation"}
+}
+
+// leaseHolderIdentity is unique per replica, the replicas of the same
+// webhook run at different hosts.
+func (m *Manager) leaseHolderIdentity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return m.secretOwner()
+	}
+	return fmt.Sprintf("%s_%s", hostname, m.secretOwner())
+}
+
+// acquireCARotation takes the CA rotation Lease so replicas do not rotate
+// the CA at the same time producing divergent CA key pairs, it returns the
+// re-calculated time left to rotate the CA since the previous holder may
+// have rotated it meanwhile.
+func (m *Manager) acquireCARotation(ctx context.Context) (time.Duration, error) {
+	acquired, err := m.acquireCARotationLease(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !acquired {
+		return 0, errors.Errorf("CA rotation Lease %s is held by other Manager, waiting for it", m.caRotationLeaseKey())
+	}
+	m.nextRotationDeadlineForCA(ctx)
+	return m.elapsedToRotateCAFromLastDeadline(ctx), nil
+}
+
+// acquireCARotationLease takes or renews the CA rotation Lease, it returns
+// false if other Manager holds it and has not expired.
+func (m *Manager) acquireCARotationLease(ctx context.Context) (bool, error) {
+	leaseKey := m.caRotationLeaseKey()
+	holder := m.leaseHolderIdentity()
+	now := metav1.NewMicroTime(m.now())
+	leaseDurationSeconds := int32(caRotationLeaseDuration.Seconds())
+
+	lease := &coordinationv1.Lease{}
+	err := m.getLease(ctx, leaseKey, lease)
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Namespace: leaseKey.Namespace, Name: leaseKey.Name},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &leaseDurationSeconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		setManagedLabel(lease)
+		err = m.client.Create(ctx, lease)
+		if apierrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, errors.Wrapf(err, "failed creating CA rotation Lease %s", leaseKey)
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed reading CA rotation Lease %s", leaseKey)
+	}
+
+	currentHolder := ""
+	if lease.Spec.HolderIdentity != nil {
+		currentHolder = *lease.Spec.HolderIdentity
+	}
+	if currentHolder != holder && currentHolder != "" && !isLeaseExpired(lease, m.now()) {
+		m.log.Info(fmt.Sprintf("CA rotation Lease %s is held by %s", leaseKey, currentHolder))
+		return false, nil
+	}
+
+	if currentHolder != holder {
+		lease.Spec.AcquireTime = &now
+	}
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.LeaseDurationSeconds = &leaseDurationSeconds
+	lease.Spec.RenewTime = &now
+	err = m.client.Update(ctx, lease)
+	if apierrors.IsConflict(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed updating CA rotation Lease %s", leaseKey)
+	}
+	return true, nil
+}
+
+// getLease reads the Lease with the API reader, the cached client would
+// start a cluster wide Lease informer that needs RBAC to list and watch
+// them, and a stale Lease would let two Managers rotate the CA.
+func (m *Manager) getLease(ctx context.Context, key types.NamespacedName, lease *coordinationv1.Lease) error {
+	if m.apiReader == nil {
+		return m.get(ctx, key, lease)
+	}
+	return m.apiReader.Get(ctx, key, lease)
+}
+
+func isLeaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expiration := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return !now.Before(expiration)
+}
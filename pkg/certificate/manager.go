@@ -17,17 +17,22 @@
 package certificate
 
 import (
+	"context"
 	"crypto/x509"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/go-logr/logr"
 
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	"k8s.io/apimachinery/pkg/types"
 	crclient "sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
 
 	"github.com/qinqon/kube-admission-webhook/pkg/certificate/triple"
 )
@@ -72,12 +77,171 @@ type Manager struct {
 	// serviceOverlapDuration Options.CertOverlapInterval
 	serviceOverlapDuration time.Duration
 
+	// renewFraction Options.RenewFraction
+	renewFraction float64
+
+	// relaxFailurePolicyOnRotation Options.RelaxFailurePolicyOnRotation
+	relaxFailurePolicyOnRotation bool
+
+	// olmPolicy Options.OLMPolicy
+	olmPolicy OLMPolicy
+
+	// manageCABundle Options.ManageCABundle
+	manageCABundle bool
+
+	// caBundleSource Options.CABundleSource
+	caBundleSource *types.NamespacedName
+
+	// monitorOnly Options.MonitorOnly
+	monitorOnly bool
+
+	// notifiers Options.Notifiers
+	notifiers []Notifier
+
+	// notificationThreshold Options.NotificationThreshold
+	notificationThreshold int
+
+	// verificationFailures counts the consecutive verification failures
+	verificationFailures int
+
+	// verificationRetryAt is when the chain is verified and rotated again
+	// after a verification failure
+	verificationRetryAt time.Time
+
+	// consecutiveFailures counts the failures per notification reason
+	consecutiveFailures map[string]int
+
+	// needLeaderElection Options.NeedLeaderElection
+	needLeaderElection bool
+
+	// lockCARotation Options.LockCARotation
+	lockCARotation bool
+
+	// sharedCASecret Options.SharedCASecret
+	sharedCASecret *types.NamespacedName
+
+	// caNamespace Options.CANamespace
+	caNamespace string
+
+	// cleanupAcknowledgements Options.CleanupAcknowledgements
+	cleanupAcknowledgements int
+
+	// workloadsToRestart Options.RestartWorkloads
+	workloadsToRestart []Workload
+
+	// tlsCertChain Options.TLSCertChain
+	tlsCertChain TLSCertChain
+
+	// includeCAInTLSCert Options.IncludeCAInTLSCert
+	includeCAInTLSCert bool
+
+	// privateKeyEncoding Options.PrivateKeyEncoding
+	privateKeyEncoding PrivateKeyEncoding
+
+	// featureGates Options.FeatureGates with the defaults
+	featureGates map[FeatureGate]bool
+
+	// waitForWebhookConfiguration Options.WaitForWebhookConfiguration
+	waitForWebhookConfiguration bool
+
+	// service Options.Service
+	service *ServiceTemplate
+
+	// hardCutover Options.HardCutover
+	hardCutover bool
+
 	// log initialized log that contains the webhook configuration name and
-	// namespace so it's easy to debug.
+	// namespace so it's easy to debug. Rotations, cleanups and any other
+	// change to the certificate chain are logged at Info, deadline
+	// calculations at V(1) and object reads and verifications at V(2).
 	log logr.Logger
 
 	// extraLabels Options.ExtraLabels
 	extraLabels map[string]string
+
+	// extraAnnotations Options.ExtraAnnotations
+	extraAnnotations map[string]string
+
+	// labelWebhookConfiguration Options.LabelWebhookConfiguration
+	labelWebhookConfiguration bool
+
+	// issuer Options.Issuer
+	issuer Issuer
+
+	// storage Options.Storage
+	storage Storage
+
+	// apiReader is the controller-runtime manager API reader, set by Add,
+	// to read objects not labeled by the Manager bypassing NewCache
+	apiReader crclient.Reader
+
+	// caCertInConfigMap Options.CACertInConfigMap
+	caCertInConfigMap bool
+
+	// caTrustStore Options.CATrustStore
+	caTrustStore bool
+
+	// caTrustStorePassword Options.CATrustStorePassword
+	caTrustStorePassword string
+
+	// certManagerAnnotations Options.CertManagerAnnotations
+	certManagerAnnotations bool
+
+	// adoptExistingSecrets Options.AdoptExistingSecrets
+	adoptExistingSecrets bool
+
+	// adoptCASecret Options.AdoptCASecret
+	adoptCASecret *types.NamespacedName
+
+	// rateLimiter Options.RateLimiter
+	rateLimiter ratelimiter.RateLimiter
+
+	// maxConcurrentReconciles Options.MaxConcurrentReconciles
+	maxConcurrentReconciles int
+
+	// cacheSyncTimeout Options.CacheSyncTimeout
+	cacheSyncTimeout time.Duration
+
+	// secretCollisionsChecked is set after the first successful
+	// checkSecretCollisions
+	secretCollisionsChecked bool
+
+	// managedObjectsLabeled is set after the first successful
+	// labelManagedObjects
+	managedObjectsLabeled bool
+
+	// selfTestInterval Options.SelfTestInterval
+	selfTestInterval time.Duration
+
+	// probeServiceTLS Options.ProbeServiceTLS
+	probeServiceTLS bool
+
+	// probeBeforeCABundleCleanup Options.ProbeBeforeCABundleCleanup
+	probeBeforeCABundleCleanup bool
+
+	// serviceIPSANs Options.ServiceIPSANs
+	serviceIPSANs bool
+
+	// canaryRotation Options.CanaryRotation
+	canaryRotation bool
+
+	// rotationHistoryLimit Options.RotationHistoryLimit
+	rotationHistoryLimit int
+
+	// auditRecorder Options.AuditRecorder
+	auditRecorder AuditRecorder
+
+	// selfTestErr is the result of the last self test
+	selfTestErr error
+
+	// selfTestMutex protects selfTestErr since it's read by healthz
+	selfTestMutex sync.Mutex
+
+	// schedule contains the deadlines computed at the last Reconcile
+	schedule Schedule
+
+	// scheduleMutex protects schedule since it's read outside Reconcile
+	scheduleMutex sync.Mutex
 }
 
 // NewManager with create a certManager that generated a secret per service
@@ -108,25 +272,92 @@ func NewManager(
 		return nil, err
 	}
 
+	clock := options.Clock
+	if clock == nil {
+		clock = RealClock{}
+	}
+
+	olmPolicy := options.OLMPolicy
+	if olmPolicy == "" {
+		olmPolicy = OLMPolicyTakeOver
+	}
+
+	notificationThreshold := options.NotificationThreshold
+	if notificationThreshold == 0 {
+		notificationThreshold = defaultNotificationThreshold
+	}
+
+	caNamespace := options.CANamespace
+	if caNamespace == "" {
+		caNamespace = options.Namespace
+	}
+
 	m := &Manager{
-		client:                 client,
-		webhookName:            options.WebhookName,
-		webhookType:            options.WebhookType,
-		namespace:              options.Namespace,
-		now:                    time.Now,
-		caCertDuration:         options.CARotateInterval,
-		caOverlapDuration:      options.CAOverlapInterval,
-		serviceCertDuration:    options.CertRotateInterval,
-		serviceOverlapDuration: options.CertOverlapInterval,
-		extraLabels:            options.ExtraLabels,
+		client:                       client,
+		webhookName:                  options.WebhookName,
+		webhookType:                  options.WebhookType,
+		namespace:                    options.Namespace,
+		now:                          clock.Now,
+		caCertDuration:               options.CARotateInterval,
+		caOverlapDuration:            options.CAOverlapInterval,
+		serviceCertDuration:          options.CertRotateInterval,
+		serviceOverlapDuration:       options.CertOverlapInterval,
+		renewFraction:                options.RenewFraction,
+		relaxFailurePolicyOnRotation: options.RelaxFailurePolicyOnRotation,
+		olmPolicy:                    olmPolicy,
+		manageCABundle:               options.ManageCABundle == nil || *options.ManageCABundle,
+		caBundleSource:               options.CABundleSource,
+		monitorOnly:                  options.MonitorOnly,
+		notifiers:                    options.Notifiers,
+		notificationThreshold:        notificationThreshold,
+		consecutiveFailures:          map[string]int{},
+		needLeaderElection:           options.NeedLeaderElection == nil || *options.NeedLeaderElection,
+		lockCARotation:               options.LockCARotation,
+		sharedCASecret:               options.SharedCASecret,
+		caNamespace:                  caNamespace,
+		cleanupAcknowledgements:      options.CleanupAcknowledgements,
+		workloadsToRestart:           options.RestartWorkloads,
+		tlsCertChain:                 options.TLSCertChain,
+		includeCAInTLSCert:           options.IncludeCAInTLSCert,
+		privateKeyEncoding:           options.PrivateKeyEncoding,
+		featureGates:                 featureGates(options.FeatureGates),
+		waitForWebhookConfiguration:  options.WaitForWebhookConfiguration,
+		service:                      options.Service,
+		hardCutover:                  options.HardCutover,
+		extraLabels:                  options.ExtraLabels,
+		extraAnnotations:             options.ExtraAnnotations,
+		labelWebhookConfiguration:    options.LabelWebhookConfiguration,
+		issuer:                       options.Issuer,
+		storage:                      newStorage(client, options),
+		caCertInConfigMap:            options.CACertInConfigMap,
+		caTrustStore:                 options.CATrustStore,
+		caTrustStorePassword:         options.CATrustStorePassword,
+		certManagerAnnotations:       options.CertManagerAnnotations,
+		adoptExistingSecrets:         options.AdoptExistingSecrets,
+		adoptCASecret:                options.AdoptCASecret,
+		rateLimiter:                  options.RateLimiter,
+		maxConcurrentReconciles:      options.MaxConcurrentReconciles,
+		cacheSyncTimeout:             options.CacheSyncTimeout,
+		selfTestInterval:             options.SelfTestInterval,
+		probeServiceTLS:              options.ProbeServiceTLS,
+		probeBeforeCABundleCleanup:   options.ProbeBeforeCABundleCleanup,
+		serviceIPSANs:                options.ServiceIPSANs,
+		canaryRotation:               options.CanaryRotation,
+		rotationHistoryLimit:         options.RotationHistoryLimit,
+		auditRecorder:                options.AuditRecorder,
+		selfTestErr:                  errors.New("webhook self test has not run yet"),
 		log: logf.Log.WithName("certificate/Manager").
 			WithValues("webhookType", options.WebhookType, "webhookName", options.WebhookName),
 	}
+	if m.issuer == nil {
+		// Read m.now on every call so it follows the Manager clock
+		m.issuer = tripleIssuer{now: func() time.Time { return m.now() }, sanOnly: options.SANOnlyCertificates}
+	}
 	return m, nil
 }
 
-func (m *Manager) getCACertsFromCABundle() ([]*x509.Certificate, error) {
-	caBundle, err := m.CABundle()
+func (m *Manager) getCACertsFromCABundle(ctx context.Context) ([]*x509.Certificate, error) {
+	caBundle, err := m.CABundleWithContext(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed getting CABundle")
 	}
@@ -142,99 +373,174 @@ func (m *Manager) getCACertsFromCABundle() ([]*x509.Certificate, error) {
 	return cas, nil
 }
 
-func (m *Manager) getLastPrependedCACertFromCABundle() (*x509.Certificate, error) {
-	cas, err := m.getCACertsFromCABundle()
-	if err != nil {
-		return nil, errors.Wrap(err, "failed getting CA certificates from CA bundle")
-	}
-	if len(cas) == 0 {
-		return nil, nil
-	}
-	return cas[0], nil
-}
-
-func (m *Manager) rotateAll() error {
+// rotateAll issues a new CA and services certificates and writes them in
+// an order that keeps the webhook working if it's interrupted: first the
+// new CA is prepended to the CABundle, the previous CA is still there so
+// the served certificates keep being trusted, then the services secrets
+// and the CA secret the last so if something fails before it the CA secret
+// does not match the services certificates and next Reconcile will force
+// a new rotation.
+// With Options.HardCutover the CABundle is replaced so there is no
+// previous CA to keep the webhook working until the new certificates are
+// served.
+func (m *Manager) rotateAll(ctx context.Context) error {
 	m.log.Info("Rotating CA cert/key")
 
-	caKeyPair, err := triple.NewCA(m.webhookName, m.caCertDuration)
+	caKeyPair, err := m.issueCA(ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed generating CA cert/key")
 	}
 
-	err = m.addCertificateToCABundle(caKeyPair.Cert)
+	// Issue all the certificates before writing anything so an issuer
+	// failure do not leave a half rotated chain
+	services, keyPairs, err := m.issueServiceCerts(ctx, caKeyPair)
 	if err != nil {
-		return errors.Wrap(err, "failed adding new CA cert to CA bundle at webhook")
+		return err
 	}
 
-	err = m.applyCASecret(caKeyPair)
+	if m.hardCutover {
+		err = m.replaceCABundle(ctx, caKeyPair.Cert)
+	} else {
+		err = m.addCertificateToCABundle(ctx, caKeyPair.Cert)
+	}
 	if err != nil {
-		return errors.Wrap(err, "failed storing CA cert/key at secret")
+		return errors.Wrap(err, "failed adding new CA cert to CA bundle at webhook")
 	}
 
 	// We have rotate the CA we need to reset the TLS removing previous certs
-	err = m.rotateServicesWithoutOverlap()
+	err = m.applyServiceCerts(ctx, caKeyPair, services, keyPairs, (*Manager).resetAndApplyTLSSecret)
 	if err != nil {
 		return errors.Wrap(err, "failed rotating services")
 	}
 
+	// The shared CA secret is already stored by issueCA
+	if m.sharedCASecret == nil {
+		err = m.applyCASecret(ctx, caKeyPair)
+		if err != nil {
+			return errors.Wrap(err, "failed storing CA cert/key at secret")
+		}
+	}
+
+	err = m.applyCATrustStore(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed storing CA trust store")
+	}
+
 	return nil
 }
 
-func (m *Manager) rotateServicesWithoutOverlap() error {
-	return m.rotateServices((*Manager).resetAndApplyTLSSecret)
+// rotateServicesCerts appends the new services certificates to the
+// previous ones, with Options.HardCutover it replaces them.
+func (m *Manager) rotateServicesCerts(ctx context.Context) error {
+	if m.hardCutover {
+		return m.rotateServices(ctx, (*Manager).resetAndApplyTLSSecret)
+	}
+	return m.rotateServicesWithOverlap(ctx)
 }
 
-func (m *Manager) rotateServicesWithOverlap() error {
-	return m.rotateServices((*Manager).appendAndApplyTLSSecret)
+func (m *Manager) rotateServicesWithOverlap(ctx context.Context) error {
+	return m.rotateServices(ctx, (*Manager).appendAndApplyTLSSecret)
 }
 
-func (m *Manager) rotateServices(applyFn func(*Manager, types.NamespacedName, *triple.KeyPair) error) error {
+func (m *Manager) rotateServices(ctx context.Context,
+	applyFn func(*Manager, context.Context, types.NamespacedName, *triple.KeyPair) error) error {
 	m.log.Info("Rotating Services cert/key")
 
-	webhook, err := m.readyWebhookConfiguration()
+	caKeyPair, err := m.getCAKeyPair(ctx)
 	if err != nil {
-		return errors.Wrap(err, "failed reading webhook configuration at services rotation")
+		return errors.Wrap(err, "failed getting CA key pair")
 	}
 
-	services, err := m.getServicesFromConfiguration(webhook)
+	services, keyPairs, err := m.issueServiceCerts(ctx, caKeyPair)
 	if err != nil {
-		return errors.Wrap(err, "failed retrieving services from clientConfig")
+		return err
 	}
 
-	caKeyPair, err := m.getCAKeyPair()
+	return m.applyServiceCerts(ctx, caKeyPair, services, keyPairs, applyFn)
+}
+
+// issueServiceCerts issues a certificate signed by caKeyPair for every
+// service at the webhook configuration, the services are sorted so they
+// are always written at the same order
+func (m *Manager) issueServiceCerts(ctx context.Context, caKeyPair *triple.KeyPair) ([]types.NamespacedName, []*triple.KeyPair, error) {
+	webhook, err := m.readyWebhookConfiguration(ctx)
 	if err != nil {
-		return errors.Wrap(err, "failed getting CA key pair")
+		return nil, nil, errors.Wrap(err, "failed reading webhook configuration at services rotation")
+	}
+
+	servicesHostnames, err := m.getServicesFromConfiguration(webhook)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed retrieving services from clientConfig")
 	}
 
-	for service, hostnames := range services {
-		keyPair, err := triple.NewServerKeyPair(
-			caKeyPair,
-			service.Name+"."+service.Namespace+".pod.cluster.local",
-			service.Name,
-			service.Namespace,
-			"cluster.local",
-			nil,
-			hostnames,
-			m.serviceCertDuration,
-		)
+	services := sortedServices(servicesHostnames)
+	keyPairs := make([]*triple.KeyPair, 0, len(services))
+	for _, service := range services {
+		var ips []string
+		ips, err = m.serviceIPs(ctx, service)
 		if err != nil {
-			return errors.Wrapf(err, "failed creating server key/cert for service %+v", service)
+			return nil, nil, errors.Wrapf(err, "failed getting IPs for service %+v", service)
 		}
-		err = applyFn(m, service, keyPair)
+		var keyPair *triple.KeyPair
+		keyPair, err = m.issueServiceCert(caKeyPair, service, servicesHostnames[service], ips)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed creating server key/cert for service %+v", service)
+		}
+		keyPairs = append(keyPairs, keyPair)
+	}
+	return services, keyPairs, nil
+}
+
+func (m *Manager) applyServiceCerts(ctx context.Context, caKeyPair *triple.KeyPair, services []types.NamespacedName,
+	keyPairs []*triple.KeyPair, applyFn func(*Manager, context.Context, types.NamespacedName, *triple.KeyPair) error) error {
+	for i, service := range services {
+		err := applyFn(m, ctx, service, keyPairs[i])
 		if err != nil {
 			return errors.Wrapf(err, "failed applying TLS secret %s", service)
 		}
+
+		// With canary rotation the first service has to be valid before
+		// touching the rest, so a bad issuer or storage only breaks one
+		// of them
+		if m.canaryRotation && i == 0 && len(services) > 1 {
+			err = m.verifyCanaryService(ctx, service, caKeyPair)
+			if err != nil {
+				return errors.Wrapf(err, "failed verifying canary service %s, not rotating the rest of services", service)
+			}
+			m.log.Info("Canary service rotated and verified", "service", service)
+		}
 	}
 
 	return nil
 }
 
+func (m *Manager) verifyCanaryService(ctx context.Context, service types.NamespacedName, caKeyPair *triple.KeyPair) error {
+	caBundle, err := m.CABundleWithContext(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed getting CABundle")
+	}
+	return m.verifyTLSSecret(ctx, service, caKeyPair, caBundle)
+}
+
+// sortedServices returns the services sorted by namespace and name so they
+// are rotated always at the same order
+func sortedServices(services map[types.NamespacedName][]string) []types.NamespacedName {
+	sorted := make([]types.NamespacedName, 0, len(services))
+	for service := range services {
+		sorted = append(sorted, service)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].String() < sorted[j].String()
+	})
+	return sorted
+}
+
 // nextRotationDeadlineForService will look at the first service at
 // webhook configuration find the secret's TLS certificate and calculate
 // next deadline, looking at first serices is fine since they certificates
 // are created/rotated at the same time
-func (m *Manager) nextRotationDeadlineForServices() time.Time {
-	webhookConf, err := m.readyWebhookConfiguration()
+func (m *Manager) nextRotationDeadlineForServices(ctx context.Context) time.Time {
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
 	if err != nil {
 		m.log.Info(fmt.Sprintf("failed getting webhook configuration, forcing rotation: %v", err))
 		return m.now()
@@ -250,7 +556,7 @@ func (m *Manager) nextRotationDeadlineForServices() time.Time {
 	// expiration time
 	var nextToExpireServiceCert *x509.Certificate
 	for service := range services {
-		tlsKeyPair, err := m.getTLSKeyPair(service)
+		tlsKeyPair, err := m.getTLSKeyPair(ctx, service)
 		if err != nil {
 			m.log.Info(fmt.Sprintf("failed getting TLS keypair from service %s , forcing rotation: %v", service, err))
 			return m.now()
@@ -273,22 +579,23 @@ func (m *Manager) nextRotationDeadlineForServices() time.Time {
 
 // nextRotationDeadlineForCA verifty that TLS chain is ok, check rotation from
 // last certificate at CABundle using nextRotationDeadlineForCert
-func (m *Manager) nextRotationDeadlineForCA() time.Time {
-	err := m.verifyTLS()
+func (m *Manager) nextRotationDeadlineForCA(ctx context.Context) time.Time {
+	err := m.verifyTLS(ctx)
 	if err != nil {
 		// Sprintf is used to prevent stack trace to be printed
 		m.log.Info(fmt.Sprintf("Bad TLS certificate chain, forcing rotation: %v", err))
 		return m.now()
 	}
 
-	// Last rotated CA cert at CABundle is the last at the slice so this
-	// calculate deadline from it.
-	caCert, err := m.getLastPrependedCACertFromCABundle()
+	// The last rotated CA is the one at the CA secret, the CABundle can
+	// contain CAs injected or reordered by other tools so the deadline is
+	// not calculated from its position there.
+	caKeyPair, err := m.getCAKeyPair(ctx)
 	if err != nil {
-		m.log.Info("Failed reading last CA cert from CABundle, forcing rotation", "err", err)
+		m.log.Info("Failed reading CA cert from CA secret, forcing rotation", "err", err)
 		return m.now()
 	}
-	nextDeadline := m.nextRotationDeadlineForCert(caCert, m.caOverlapDuration)
+	nextDeadline := m.nextRotationDeadlineForCert(caKeyPair.Cert, m.caOverlapDuration)
 
 	// Store last calculated deadline to use it at Reconcile
 	m.lastRotateDeadline = &nextDeadline
@@ -297,18 +604,23 @@ func (m *Manager) nextRotationDeadlineForCA() time.Time {
 
 // nextRotationDeadlineForCert returns a value for the threshold at which the
 // current certificate should be rotated, the expiration of the
-// certificate - overlap
+// certificate - overlap or the Options.RenewFraction of its duration if
+// set
 func (m *Manager) nextRotationDeadlineForCert(certificate *x509.Certificate, overlap time.Duration) time.Time {
 	notAfter := certificate.NotAfter
 	totalDuration := float64(notAfter.Sub(certificate.NotBefore))
 	deadlineDuration := totalDuration - float64(overlap)
+	if m.renewFraction > 0 {
+		deadlineDuration = totalDuration * m.renewFraction
+	}
 	deadline := certificate.NotBefore.Add(time.Duration(deadlineDuration))
 
-	m.log.Info(fmt.Sprintf("Certificate expiration is %v, totalDuration is %v, rotation deadline is %v", notAfter, totalDuration, deadline))
+	m.log.V(1).Info(fmt.Sprintf("Certificate expiration is %v, totalDuration is %v, rotation deadline is %v",
+		notAfter, totalDuration, deadline))
 	return deadline
 }
 
-func (m *Manager) elapsedToRotateCAFromLastDeadline() time.Duration {
+func (m *Manager) elapsedToRotateCAFromLastDeadline(ctx context.Context) time.Duration {
 	deadline := m.now() //nolint:staticcheck // lint mark it as unused
 
 	// If deadline was previously calculated return it, else do the
@@ -316,15 +628,16 @@ func (m *Manager) elapsedToRotateCAFromLastDeadline() time.Duration {
 	if m.lastRotateDeadline != nil {
 		deadline = *m.lastRotateDeadline
 	} else {
-		deadline = m.nextRotationDeadlineForCA()
+		deadline = m.nextRotationDeadlineForCA(ctx)
 	}
 	now := m.now()
 	elapsedToRotate := deadline.Sub(now)
-	m.log.Info(fmt.Sprintf("elapsedToRotateCAFromLastDeadline {now: %s, deadline: %s, elapsedToRotate: %s}", now, deadline, elapsedToRotate))
+	m.log.V(1).Info(fmt.Sprintf("elapsedToRotateCAFromLastDeadline {now: %s, deadline: %s, elapsedToRotate: %s}",
+		now, deadline, elapsedToRotate))
 	return elapsedToRotate
 }
 
-func (m *Manager) elapsedToRotateServicesFromLastDeadline() time.Duration {
+func (m *Manager) elapsedToRotateServicesFromLastDeadline(ctx context.Context) time.Duration {
 	deadline := m.now() //nolint:staticcheck // lint mark it as unused
 
 	// If deadline was previously calculated return it, else do the
@@ -332,43 +645,36 @@ func (m *Manager) elapsedToRotateServicesFromLastDeadline() time.Duration {
 	if m.lastRotateDeadlineForServices != nil {
 		deadline = *m.lastRotateDeadlineForServices
 	} else {
-		deadline = m.nextRotationDeadlineForServices()
+		deadline = m.nextRotationDeadlineForServices(ctx)
 	}
 	now := m.now()
 	elapsedToRotate := deadline.Sub(now)
-	m.log.Info(fmt.Sprintf("elapsedToRotateServicesFromLastDeadline{now: %s, deadline: %s, elapsedToRotate: %s}",
+	m.log.V(1).Info(fmt.Sprintf("elapsedToRotateServicesFromLastDeadline{now: %s, deadline: %s, elapsedToRotate: %s}",
 		now, deadline, elapsedToRotate))
 	return elapsedToRotate
 }
 
 // verifyTLS will verify that the caBundle and Secret are valid and can
 // be used to verify
-func (m *Manager) verifyTLS() error {
-	webhookConf, err := m.readyWebhookConfiguration()
+func (m *Manager) verifyTLS(ctx context.Context) error {
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed to reading configuration")
 	}
 
-	caKeyPair, err := m.getCAKeyPair()
+	caKeyPair, err := m.getCAKeyPair(ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed getting CA keypair from secret to verify TLS")
 	}
 
 	for _, clientConfig := range m.clientConfigList(webhookConf) {
-		service := clientConfig.Service
-		secretKey := types.NamespacedName{}
-		if service != nil {
-			// If the webhook has a service then create the secret
-			// with same namespce and name
-			secretKey.Name = service.Name
-			secretKey.Namespace = service.Namespace
-		} else {
-			// If it uses directly URL create a secret with webhookName and
-			// mgr namespace
-			secretKey.Name = m.webhookName
-			secretKey.Namespace = m.namespace
+		secretKey := m.secretKeyForClientConfig(clientConfig)
+		var caBundle []byte
+		caBundle, err = m.clientConfigCABundle(ctx, clientConfig)
+		if err != nil {
+			return errors.Wrap(err, "failed getting CABundle to verify TLS")
 		}
-		err = m.verifyTLSSecret(secretKey, caKeyPair, clientConfig.CABundle)
+		err = m.verifyTLSSecret(ctx, secretKey, caKeyPair, caBundle)
 		if err != nil {
 			return errors.Wrapf(err, "failed verifying TLS secret %s", secretKey)
 		}
@@ -376,3 +682,21 @@ func (m *Manager) verifyTLS() error {
 
 	return nil
 }
+
+// secretKeyForClientConfig returns the TLS secret for the clientConfig
+func (m *Manager) secretKeyForClientConfig(clientConfig *admissionregistrationv1.WebhookClientConfig) types.NamespacedName {
+	service := clientConfig.Service
+	secretKey := types.NamespacedName{}
+	if service != nil {
+		// If the webhook has a service then create the secret
+		// with same namespce and name
+		secretKey.Name = service.Name
+		secretKey.Namespace = service.Namespace
+	} else {
+		// If it uses directly URL create a secret with webhookName and
+		// mgr namespace
+		secretKey.Name = m.webhookName
+		secretKey.Namespace = m.namespace
+	}
+	return secretKey
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	scheduleEventCARotation          = "ca_rotation"
+	scheduleEventServiceRotation     = "service_rotation"
+	scheduleEventCABundleCleanup     = "ca_bundle_cleanup"
+	scheduleEventServiceCertsCleanup = "service_certs_cleanup"
+
+	reconcileResultSuccess = "success"
+	reconcileResultError   = "error"
+	reconcileResultBackoff = "backoff"
+)
+
+var (
+	nextEventTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kube_admission_webhook_certificate_next_event_timestamp_seconds",
+			Help: "Unix timestamp of the next scheduled certificate rotation or cleanup per webhook, type and event",
+		},
+		[]string{"webhook", "type", "event"},
+	)
+	requeueAfterSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kube_admission_webhook_certificate_requeue_after_seconds",
+			Help: "Seconds until the certificate controller reconciles the webhook again",
+		},
+		[]string{"webhook", "type"},
+	)
+	selfTestSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kube_admission_webhook_selftest_success",
+			Help: "Whether the last webhook self test succeeded (1) or failed (0)",
+		},
+		[]string{"webhook", "type"},
+	)
+	verificationSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kube_admission_webhook_certificate_verification_success",
+			Help: "Whether the last certificate chain verification at monitor only mode succeeded (1) or failed (0)",
+		},
+		[]string{"webhook", "type"},
+	)
+	expirationTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kube_admission_webhook_certificate_expiration_timestamp_seconds",
+			Help: "Unix timestamp of the last issued CA and services certificates expiration at monitor only mode",
+		},
+		[]string{"webhook", "type", "certificate"},
+	)
+	verificationFailureCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kube_admission_webhook_certificate_verification_failures",
+			Help: "Consecutive certificate chain verification failures, rotating does not fix them if bigger than 1",
+		},
+		[]string{"webhook", "type"},
+	)
+	reconcileDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "kube_admission_webhook_certificate_reconcile_duration_seconds",
+			Help:    "Duration of the certificate controller reconciles per webhook, type and result",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+		},
+		[]string{"webhook", "type", "result"},
+	)
+	reconcileTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kube_admission_webhook_certificate_reconcile_total",
+			Help: "Certificate controller reconciles per webhook, type and result",
+		},
+		[]string{"webhook", "type", "result"},
+	)
+	registerMetricsOnce sync.Once
+)
+
+// registerMetrics add the certificate gauges to the controller-runtime
+// metrics registry, so they are served by the manager metrics endpoint.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		metrics.Registry.MustRegister(nextEventTimestamp, requeueAfterSeconds, selfTestSuccess, verificationSuccess, expirationTimestamp,
+			verificationFailureCount, reconcileDuration, reconcileTotal)
+	})
+}
+
+// Schedule contains the deadlines computed at the last Reconcile
+type Schedule struct {
+	// CARotation is when the CA and all the service certificates will be rotated
+	CARotation time.Time
+
+	// ServiceRotation is when the service certificates will be rotated
+	ServiceRotation time.Time
+
+	// CABundleCleanup is when expired CA certificates will be removed from the CABundle
+	CABundleCleanup time.Time
+
+	// ServiceCertsCleanup is when expired certificates will be removed from the service secrets
+	ServiceCertsCleanup time.Time
+
+	// RequeueAfter is the time left, from the last Reconcile, to the next one
+	RequeueAfter time.Duration
+}
+
+// NextSchedule returns the rotation and cleanup deadlines computed at the
+// last Reconcile, it will be empty if no Reconcile has finished yet.
+func (m *Manager) NextSchedule() Schedule {
+	m.scheduleMutex.Lock()
+	defer m.scheduleMutex.Unlock()
+	return m.schedule
+}
+
+// recordSchedule stores the deadlines computed at Reconcile and publish them
+// as metrics.
+func (m *Manager) recordSchedule(schedule Schedule) {
+	m.scheduleMutex.Lock()
+	m.schedule = schedule
+	m.scheduleMutex.Unlock()
+
+	webhookType := string(m.webhookType)
+	nextEventTimestamp.WithLabelValues(m.webhookName, webhookType, scheduleEventCARotation).Set(unixSeconds(schedule.CARotation))
+	nextEventTimestamp.WithLabelValues(m.webhookName, webhookType, scheduleEventServiceRotation).Set(unixSeconds(schedule.ServiceRotation))
+	nextEventTimestamp.WithLabelValues(m.webhookName, webhookType, scheduleEventCABundleCleanup).Set(unixSeconds(schedule.CABundleCleanup))
+	nextEventTimestamp.WithLabelValues(m.webhookName, webhookType, scheduleEventServiceCertsCleanup).Set(
+		unixSeconds(schedule.ServiceCertsCleanup))
+	requeueAfterSeconds.WithLabelValues(m.webhookName, webhookType).Set(schedule.RequeueAfter.Seconds())
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// recordReconcile publishes the duration and result of a Reconcile, the
+// requeue after of the verification backoff is published too since it
+// does not go through recordSchedule.
+func (m *Manager) recordReconcile(start time.Time, result string, requeueAfter time.Duration) {
+	reconcileDuration.WithLabelValues(m.webhookName, string(m.webhookType), result).Observe(time.Since(start).Seconds())
+	reconcileTotal.WithLabelValues(m.webhookName, string(m.webhookType), result).Inc()
+	if result == reconcileResultBackoff {
+		requeueAfterSeconds.WithLabelValues(m.webhookName, string(m.webhookType)).Set(requeueAfter.Seconds())
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// monitorOnlyInterval is the maximum time between verifications at
+// Options.MonitorOnly
+const monitorOnlyInterval = 5 * time.Minute
+
+// reconcileMonitorOnly verifies the certificate chain and records the
+// result and the certificates expiration as metrics without writing
+// anything, it requeues at monitorOnlyInterval or at the next expiration
+// if it's sooner.
+func (m *Manager) reconcileMonitorOnly(ctx context.Context) reconcile.Result {
+	err := m.verifyTLS(ctx)
+	m.trackFailure(NotificationReasonVerificationFailed, err)
+	if err != nil {
+		m.log.Info(fmt.Sprintf("TLS certificate chain failed verification: %v", err))
+		verificationSuccess.WithLabelValues(m.webhookName, string(m.webhookType)).Set(0)
+	} else {
+		verificationSuccess.WithLabelValues(m.webhookName, string(m.webhookType)).Set(1)
+	}
+
+	requeueAfter := monitorOnlyInterval
+	inspection, err := m.InspectChain(ctx)
+	if err != nil {
+		m.log.Info(fmt.Sprintf("Failed inspecting certificate chain: %v", err))
+		return reconcile.Result{RequeueAfter: requeueAfter}
+	}
+
+	expirations := map[string]time.Time{}
+	if len(inspection.CABundle) > 0 {
+		expirations["ca"] = inspection.CABundle[0].NotAfter
+	}
+	for _, service := range inspection.Services {
+		if len(service.Certificates) > 0 {
+			expirations[fmt.Sprintf("service/%s/%s", service.Namespace, service.Name)] = service.Certificates[0].NotAfter
+		}
+	}
+
+	now := m.now()
+	for certificate, notAfter := range expirations {
+		expirationTimestamp.WithLabelValues(m.webhookName, string(m.webhookType), certificate).Set(unixSeconds(notAfter))
+		m.log.V(1).Info("Certificate expiration", "certificate", certificate, "notAfter", notAfter, "timeToExpiry", notAfter.Sub(now))
+		if timeToExpiry := notAfter.Sub(now); timeToExpiry > 0 && timeToExpiry < requeueAfter {
+			requeueAfter = timeToExpiry
+		}
+	}
+	return reconcile.Result{RequeueAfter: requeueAfter}
+}
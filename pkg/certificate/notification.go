@@ -0,0 +1,224 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// NotificationReasonReconcileFailed is notified when the rotation or
+	// cleanup of the certificates fails
+	NotificationReasonReconcileFailed = "ReconcileFailed"
+
+	// NotificationReasonVerificationFailed is notified when the
+	// certificate chain fails verification
+	NotificationReasonVerificationFailed = "VerificationFailed"
+
+	defaultNotificationThreshold = 3
+	notificationTimeout          = 10 * time.Second
+	notificationQueueSize        = 16
+)
+
+// Event is notified when the certificates rotation or verification
+// fails Options.NotificationThreshold consecutive times
+type Event struct {
+	// WebhookName is the webhook configuration of the failing certificates
+	WebhookName string `json:"webhookName"`
+
+	// WebhookType is the webhook configuration type
+	WebhookType WebhookType `json:"webhookType"`
+
+	// Reason is NotificationReasonReconcileFailed or
+	// NotificationReasonVerificationFailed
+	Reason string `json:"reason"`
+
+	// Message is the last failure error
+	Message string `json:"message"`
+
+	// Failures is the number of consecutive failures
+	Failures int `json:"failures"`
+
+	// Time is when the last failure happened
+	Time time.Time `json:"time"`
+}
+
+// Notifier sends the certificates failures events to the systems
+// alerting about them, Notify must not block.
+type Notifier interface {
+	Notify(event Event) error
+}
+
+// NotifierFunc adapts a function to a Notifier
+type NotifierFunc func(event Event) error
+
+// Notify calls f(event)
+func (f NotifierFunc) Notify(event Event) error {
+	return f(event)
+}
+
+type logNotifier struct {
+	log logr.Logger
+}
+
+// NewLogNotifier returns a Notifier that logs the events with log
+func NewLogNotifier(log logr.Logger) Notifier {
+	return logNotifier{log: log}
+}
+
+func (n logNotifier) Notify(event Event) error {
+	n.log.Error(errors.New(event.Message), "Certificates failure",
+		"webhookType", event.WebhookType, "webhookName", event.WebhookName,
+		"reason", event.Reason, "failures", event.Failures)
+	return nil
+}
+
+type eventNotifier struct {
+	recorder record.EventRecorder
+}
+
+// NewEventNotifier returns a Notifier that records the events as
+// Kubernetes Warning events at the webhook configuration, the recorder
+// can be obtained from controller-runtime manager.GetEventRecorderFor
+func NewEventNotifier(recorder record.EventRecorder) Notifier {
+	return eventNotifier{recorder: recorder}
+}
+
+func (n eventNotifier) Notify(event Event) error {
+	kind := "MutatingWebhookConfiguration"
+	if event.WebhookType == ValidatingWebhook {
+		kind = "ValidatingWebhookConfiguration"
+	}
+	webhookConfiguration := &corev1.ObjectReference{
+		APIVersion: admissionregistrationv1.SchemeGroupVersion.String(),
+		Kind:       kind,
+		Name:       event.WebhookName,
+	}
+	n.recorder.Eventf(webhookConfiguration, corev1.EventTypeWarning, event.Reason,
+		"%s (%d consecutive failures)", event.Message, event.Failures)
+	return nil
+}
+
+type httpNotifier struct {
+	url   string
+	queue chan []byte
+	log   logr.Logger
+}
+
+// NewHTTPNotifier returns a Notifier that POSTs the events as JSON to url,
+// the events are queued and POSTed from a goroutine so a slow endpoint does
+// not delay the Reconcile, if the queue is full the event is dropped and
+// Notify fails.
+func NewHTTPNotifier(url string) Notifier {
+	n := &httpNotifier{
+		url:   url,
+		queue: make(chan []byte, notificationQueueSize),
+		log:   logf.Log.WithName("certificate/httpNotifier"),
+	}
+	go n.run()
+	return n
+}
+
+func (n *httpNotifier) Notify(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "failed marshaling notification")
+	}
+	select {
+	case n.queue <- body:
+		return nil
+	default:
+		return errors.Errorf("notification queue for %s is full, dropping notification", n.url)
+	}
+}
+
+func (n *httpNotifier) run() {
+	for body := range n.queue {
+		err := n.post(body)
+		if err != nil {
+			n.log.Info(fmt.Sprintf("Failed notifying certificates failure: %v", err))
+		}
+	}
+}
+
+func (n *httpNotifier) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed creating notification request")
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return errors.Wrapf(err, "failed sending notification to %s", n.url)
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return errors.Errorf("notification to %s answered with status %d", n.url, response.StatusCode)
+	}
+	return nil
+}
+
+// trackFailure counts the consecutive failures for reason, err nil resets
+// them, and notifies every Options.NotificationThreshold failures.
+// It's only called from Reconcile so it's never called concurrently.
+func (m *Manager) trackFailure(reason string, err error) {
+	if len(m.notifiers) == 0 {
+		return
+	}
+	if err == nil {
+		delete(m.consecutiveFailures, reason)
+		return
+	}
+	m.consecutiveFailures[reason]++
+	failures := m.consecutiveFailures[reason]
+	if failures%m.notificationThreshold != 0 {
+		return
+	}
+	m.notify(Event{
+		WebhookName: m.webhookName,
+		WebhookType: m.webhookType,
+		Reason:      reason,
+		Message:     err.Error(),
+		Failures:    failures,
+		Time:        m.now().UTC(),
+	})
+}
+
+func (m *Manager) notify(event Event) {
+	m.log.Info("Notifying certificates failure", "reason", event.Reason, "failures", event.Failures)
+	for _, notifier := range m.notifiers {
+		err := notifier.Notify(event)
+		if err != nil {
+			m.log.Info(fmt.Sprintf("Failed notifying certificates failure: %v", err))
+		}
+	}
+}
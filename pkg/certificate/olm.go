@@ -0,0 +1,86 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// OLMOwnerLabelKey is the label Operator Lifecycle Manager sets at the
+// webhook configurations it owns, OLM injects their CABundle and rotates
+// their certificates.
+const OLMOwnerLabelKey = "olm.owner"
+
+// OLMPolicy is what the Manager does with a webhook configuration owned
+// by OLM
+type OLMPolicy string
+
+const (
+	// OLMPolicyTakeOver manages the certificates as with any other webhook
+	// configuration, OLM and the Manager will overwrite each other CABundle
+	OLMPolicyTakeOver OLMPolicy = "TakeOver"
+
+	// OLMPolicySkip does not touch the webhook configuration nor the
+	// certificates
+	OLMPolicySkip OLMPolicy = "Skip"
+
+	// OLMPolicyVerifyOnly does not write anything, it only verifies that
+	// the certificates served by the webhooks chain to the CABundle
+	// injected by OLM
+	OLMPolicyVerifyOnly OLMPolicy = "VerifyOnly"
+)
+
+func isOLMOwned(webhookConf client.Object) bool {
+	_, found := webhookConf.GetLabels()[OLMOwnerLabelKey]
+	return found
+}
+
+// reconcileOLMOwned applies Options.OLMPolicy if the webhook configuration
+// is owned by OLM, it returns false if the Reconcile has to continue.
+func (m *Manager) reconcileOLMOwned(ctx context.Context) (bool, reconcile.Result, error) {
+	if m.olmPolicy == OLMPolicyTakeOver {
+		return false, reconcile.Result{}, nil
+	}
+
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
+	if err != nil {
+		return true, reconcile.Result{}, errors.Wrap(err, "failed reading webhook configuration")
+	}
+
+	if !isOLMOwned(webhookConf) {
+		return false, reconcile.Result{}, nil
+	}
+
+	if m.olmPolicy == OLMPolicySkip {
+		m.log.Info("Skipping webhook configuration owned by OLM")
+		return true, reconcile.Result{}, nil
+	}
+
+	m.log.Info("Verifying certificates served by webhook configuration owned by OLM")
+	for _, clientConfig := range m.clientConfigList(webhookConf) {
+		err = probeServedCertificate(ctx, clientConfig, clientConfig.CABundle)
+		if err != nil {
+			return true, reconcile.Result{}, errors.Wrap(err, "failed verifying webhook TLS with OLM CA bundle")
+		}
+	}
+	return true, reconcile.Result{}, nil
+}
@@ -19,6 +19,12 @@ package certificate
 import (
 	"fmt"
 	"time"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/ratelimiter"
 )
 
 type WebhookType string
@@ -41,6 +47,13 @@ type Options struct {
 	// for ClientConfig that has URL instead of ServiceRef
 	Namespace string
 
+	// CANamespace is a central namespace for the CA secret, so platform
+	// teams can keep one place for the CAs of many team owned webhooks
+	// while the services secrets stay at the services namespaces, the
+	// controller-runtime cache has to include both. If not set Namespace
+	// is used
+	CANamespace string
+
 	// CARotateInterval configurated duration for CA and certificate
 	CARotateInterval time.Duration
 
@@ -57,35 +70,414 @@ type Options struct {
 	// not set it will default to CertRotateInterval
 	CertOverlapInterval time.Duration
 
-	// ExtraLabels extra labels that will be added to created secrets
+	// CARenewBefore rotates the CA when less than it remains to expire,
+	// like cert-manager renewBefore, it's the same window as
+	// CAOverlapInterval so they can not be set to different values
+	CARenewBefore time.Duration
+
+	// CertRenewBefore rotates the service certificates when less than it
+	// remains to expire, it's the same window as CertOverlapInterval so
+	// they can not be set to different values
+	CertRenewBefore time.Duration
+
+	// RenewFraction is the fraction of the CA and service certificates
+	// lifetime after which they are rotated, for example 2.0/3 rotates
+	// them at two thirds of their duration. If set it takes precedence
+	// over CAOverlapInterval and CertOverlapInterval so the rotation keeps
+	// proportional when the rotate intervals change
+	RenewFraction float64
+
+	// RelaxFailurePolicyOnRotation sets the webhooks failurePolicy to
+	// Ignore during the rotations and restores it after them, so a
+	// rotation going wrong midway does not reject the admission requests.
+	// The Manager needs update permission on the webhook configuration
+	RelaxFailurePolicyOnRotation bool
+
+	// OLMPolicy is what to do with webhook configurations owned by
+	// Operator Lifecycle Manager, labeled with OLMOwnerLabelKey, so the
+	// Manager and OLM do not overwrite each other CABundle. If not set
+	// OLMPolicyTakeOver is used
+	OLMPolicy OLMPolicy
+
+	// ManageCABundle false makes the Manager issue and rotate the secrets
+	// without writing the webhook configurations CABundle, so other tool
+	// (OpenShift service CA, cert-manager cainjector...) owns it. The
+	// CABundle is kept at the CA secret CABundleKey and at the TLS secrets
+	// ca.crt for the tool to inject it. If not set it's true
+	ManageCABundle *bool
+
+	// CABundleSource is a secret with the CA certificates at its ca.crt
+	// key, if set the services TLS secrets are issued externally and the
+	// Manager only keeps the webhook configuration CABundle in sync with
+	// it and verifies the secrets against it, it never writes secrets
+	CABundleSource *types.NamespacedName
+
+	// MonitorOnly makes the Manager never write anything, it periodically
+	// verifies the certificate chain and exposes the result and the
+	// certificates expiration as metrics, for users rotating the
+	// certificates manually
+	MonitorOnly bool
+
+	// Notifiers are notified with an Event when the certificates rotation
+	// or verification fails NotificationThreshold consecutive times, so the
+	// failures reach alerting systems that do not scrape metrics, see
+	// NewLogNotifier, NewEventNotifier and NewHTTPNotifier
+	Notifiers []Notifier
+
+	// NotificationThreshold is the number of consecutive failures that
+	// notifies the Notifiers, if not set 3 is used
+	NotificationThreshold int
+
+	// NeedLeaderElection false runs the certificate controller and the
+	// self test at every replica instead of only at the leader, so
+	// single-purpose cert manager deployments keep rotating if the leader
+	// is down. If not set it's true
+	NeedLeaderElection *bool
+
+	// LockCARotation makes the Manager take a <CA secret>-rotation
+	// coordination Lease before rotating the CA, so the replicas of a
+	// multi-replica deployment with NeedLeaderElection false do not
+	// rotate it at the same time producing divergent CA key pairs. It
+	// needs RBAC to read, create and update leases
+	LockCARotation bool
+
+	// SharedCASecret is a CA secret shared with the Managers of other
+	// processes instead of <WebhookName>-ca, the CA is read if present and
+	// created if absent, and only the Manager holding the
+	// <SharedCASecret>-rotation coordination Lease rotates it, the others
+	// follow it updating their CABundle and services certificates. It
+	// needs RBAC to read, create and update leases
+	SharedCASecret *types.NamespacedName
+
+	// CleanupAcknowledgements is the number of webhook replicas that have
+	// to acknowledge serving the newest certificate of every TLS secret,
+	// calling AcknowledgeCertificate, before the previous CAs and
+	// certificates are removed, so HA deployments do not depend on the
+	// kubelet secret propagation timing. If not set the cleanup only waits
+	// for the overlap intervals
+	CleanupAcknowledgements int
+
+	// RestartWorkloads are rolled out, setting the
+	// RestartedAtAnnotationKey pod template annotation, after the services
+	// certificates change, for webhook servers that only read them at
+	// startup. It needs RBAC to get and patch them
+	RestartWorkloads []Workload
+
+	// TLSCertChain selects the certificates written at the TLS secrets
+	// "tls.crt", if not set TLSCertChainOverlap is used
+	TLSCertChain TLSCertChain
+
+	// IncludeCAInTLSCert appends the issuing CA certificate to the TLS
+	// secrets "tls.crt", for clients that can not be configured with the
+	// CABundle like some legacy HTTP probes
+	IncludeCAInTLSCert bool
+
+	// HardCutover disables the overlap, rotations replace the CABundle CA
+	// and the services certificates instead of prepending the new ones,
+	// the CAs at the CABundle injected by other tools are kept,
+	// the webhook fails until it reloads the new certificate. Useful with
+	// very short lived certificates, the overlap intervals are used only
+	// to calculate the rotation deadline
+	HardCutover bool
+
+	// ExtraLabels extra labels that will be added to created secrets and
+	// configmaps
 	ExtraLabels map[string]string
+
+	// ExtraAnnotations extra annotations that will be added to created
+	// secrets and configmaps
+	ExtraAnnotations map[string]string
+
+	// LabelWebhookConfiguration adds ExtraLabels, ExtraAnnotations and the
+	// managed label also to the webhook configuration each time its
+	// CABundle is updated, so it can be discovered by label
+	LabelWebhookConfiguration bool
+
+	// Issuer generates the CA and service certificates, if not set the
+	// certificates are self signed using the triple package
+	Issuer Issuer
+
+	// SANOnlyCertificates issues the services certificates with an empty
+	// CommonName, clients rely only on the DNS and IP SANs, it can not be
+	// used with Issuer
+	SANOnlyCertificates bool
+
+	// Storage reads and writes the secrets and configmaps with the
+	// certificate chain, if not set they are stored at the cluster with the
+	// Manager client
+	Storage Storage
+
+	// PrivateKeyEncoding is the PEM encoding of the private keys at the
+	// secrets, if not set PrivateKeyEncodingPKCS1 is used
+	PrivateKeyEncoding PrivateKeyEncoding
+
+	// CACertInConfigMap stores the CA certificate at a ConfigMap with the
+	// same name as the CA secret, the CA secret only keeps the private key
+	// so components that just need the trust anchor do not need access to
+	// it
+	CACertInConfigMap bool
+
+	// CATrustStore renders the CA certificates from the CABundle as a JKS
+	// trust store at the "truststore.jks" key of the CA secret, or of the
+	// CA ConfigMap if CACertInConfigMap is set, so JVM consumers can trust
+	// the webhook CA without conversion jobs
+	CATrustStore bool
+
+	// CATrustStorePassword protects the JKS trust store integrity, if not
+	// set it will default to DefaultTrustStorePassword
+	CATrustStorePassword string
+
+	// CertManagerAnnotations adds the cert-manager.io annotations
+	// (common-name, alt-names, issuer-name...) to the managed secrets so
+	// tooling built around cert-manager secrets works with them
+	CertManagerAnnotations bool
+
+	// AdoptExistingSecrets makes the Manager take ownership of services
+	// TLS secrets not created by it (manually or by other tools) if they
+	// are valid for the current CA and CABundle, instead of overwriting
+	// them, they will be rotated once they are close to expire
+	AdoptExistingSecrets bool
+
+	// AdoptCASecret is a kubernetes.io/tls secret with the CA that issued
+	// the existing services TLS secrets, it's imported into the CA secret
+	// and the CABundle if the CA secret does not exist yet so they are
+	// adopted instead of overwritten at the first Reconcile
+	AdoptCASecret *types.NamespacedName
+
+	// RateLimiter limits how frequently the certificate controller retries
+	// a failed Reconcile, for example workqueue.NewItemExponentialFailureRateLimiter,
+	// if not set the controller-runtime default rate limiter is used
+	RateLimiter ratelimiter.RateLimiter
+
+	// MaxConcurrentReconciles is the number of certificate controller
+	// workers, every Manager has its own controller so the chains of
+	// different webhooks reconcile in parallel, the events of one chain
+	// share the same key so it's never reconciled concurrently.
+	// If not set the controller-runtime default is used
+	MaxConcurrentReconciles int
+
+	// CacheSyncTimeout is the time limit to wait for the certificate
+	// controller caches to sync, if not set the controller-runtime default
+	// is used
+	CacheSyncTimeout time.Duration
+
+	// SelfTestInterval enables sending an AdmissionReview periodically to
+	// the webhooks through the full service DNS and TLS path, they have to
+	// serve SelfTestHandler at SelfTestPath, the result is exposed by
+	// Manager.SelfTestCheck and metrics. If not set self test is disabled
+	SelfTestInterval time.Duration
+
+	// ProbeServiceTLS dials the webhook services before adding a new CA to
+	// their CABundle, the update fails, and is retried at next Reconcile, if
+	// the certificate they serve does not chain to the new CABundle, the
+	// removal of the previous CAs is gated by ProbeBeforeCABundleCleanup
+	ProbeServiceTLS bool
+
+	// ProbeBeforeCABundleCleanup dials the webhook services before removing
+	// the previous CAs from the CABundle and postpones the cleanup until
+	// they serve a certificate issued by the current CA, this covers the
+	// time the pods take to refresh the TLS secret volume
+	ProbeBeforeCABundleCleanup bool
+
+	// ServiceIPSANs adds the ClusterIPs and LoadBalancer ingress IPs of
+	// the webhook services as IP SANs of their certificates, for clients
+	// connecting by IP, the IPs are read when the certificates are issued.
+	// The Manager needs get, list and watch permissions on services and a
+	// custom Issuer has to implement ServiceIPsIssuer
+	ServiceIPSANs bool
+
+	// CanaryRotation rotates the first service, sorted by namespace and
+	// name, and verifies its secret against the CA and CABundle before
+	// rotating the rest of services referenced by the webhook
+	CanaryRotation bool
+
+	// RotationHistoryLimit is the number of rotations recorded, with the
+	// certificate fingerprint and expiration, at the secrets
+	// RotationHistoryAnnotationKey annotation. If not set the history is
+	// not recorded
+	RotationHistoryLimit int
+
+	// AuditRecorder receives an AuditEvent for every rotation, cleanup and
+	// forced action, like NewConfigMapAuditRecorder. If not set no audit
+	// trail is recorded
+	AuditRecorder AuditRecorder
+
+	// WaitForWebhookConfiguration starts the certificate controller once
+	// the webhook configuration exists, for configurations created after
+	// the operator starts, like by Helm hooks or OLM, instead of failing
+	// the first reconciles
+	WaitForWebhookConfiguration bool
+
+	// Service creates or updates the Services referenced by the webhook
+	// configuration, with the serviceRefs names and ports, so there is no
+	// need to deploy them. If not set they are not touched
+	Service *ServiceTemplate
+
+	// FeatureGates enables or disables the FeatureGate behaviors, the
+	// gates not set keep their default
+	FeatureGates map[FeatureGate]bool
+
+	// Clock returns the current time used to issue certificates and
+	// calculate the rotation and cleanup deadlines, if not set RealClock
+	// is used
+	Clock Clock
 }
 
+// validate checks all the options and returns every violation found
+// aggregated, so they can be fixed at once.
 func (o *Options) validate() error {
+	errs := []error{}
+	errs = append(errs, o.validateWebhook()...)
+	errs = append(errs, o.validateIntervals()...)
+	errs = append(errs, o.validateCABundle()...)
+	errs = append(errs, o.validateSharedCASecret()...)
+	errs = append(errs, o.validateSecrets()...)
+	errs = append(errs, o.validateController()...)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.Wrap(utilerrors.NewAggregate(errs), "failed validating certificate options")
+}
+
+func (o *Options) validateWebhook() []error {
+	errs := []error{}
 	if o.WebhookName == "" {
-		return fmt.Errorf("failed validating certificate options, 'WebhookName' field is missing")
+		errs = append(errs, fmt.Errorf("'WebhookName' field is missing"))
 	}
 	if o.Namespace == "" {
-		return fmt.Errorf("failed validating certificate options, 'Namespace' field is missing")
+		errs = append(errs, fmt.Errorf("'Namespace' field is missing"))
+	}
+	if o.WebhookType != MutatingWebhook && o.WebhookType != ValidatingWebhook {
+		errs = append(errs, fmt.Errorf("'WebhookType' has to be %s or %s", MutatingWebhook, ValidatingWebhook))
+	}
+	if o.OLMPolicy != "" && o.OLMPolicy != OLMPolicyTakeOver && o.OLMPolicy != OLMPolicySkip && o.OLMPolicy != OLMPolicyVerifyOnly {
+		errs = append(errs, fmt.Errorf("'OLMPolicy' has to be %s, %s or %s", OLMPolicyTakeOver, OLMPolicySkip, OLMPolicyVerifyOnly))
 	}
+	if err := validateFeatureGates(o.FeatureGates); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
 
+func (o *Options) validateIntervals() []error {
+	errs := []error{}
 	if o.CAOverlapInterval > o.CARotateInterval {
-		return fmt.Errorf("failed validating certificate options, 'CAOverlapInterval' has to be <= 'CARotateInterval'")
+		errs = append(errs, fmt.Errorf("'CAOverlapInterval' has to be <= 'CARotateInterval'"))
 	}
-
 	if o.CertRotateInterval > o.CARotateInterval {
-		return fmt.Errorf("failed validating certificate options, 'CertRotateInterval' has to be <= 'CARotateInterval'")
+		errs = append(errs, fmt.Errorf("'CertRotateInterval' has to be <= 'CARotateInterval'"))
 	}
-
 	if o.CertOverlapInterval > o.CertRotateInterval {
-		return fmt.Errorf("failed validating certificate options, 'CertOverlapInterval' has to be <= 'CertRotateInterval'")
+		errs = append(errs, fmt.Errorf("'CertOverlapInterval' has to be <= 'CertRotateInterval'"))
+	}
+	if o.CARenewBefore != 0 && o.CARenewBefore != o.CAOverlapInterval {
+		errs = append(errs, fmt.Errorf("'CARenewBefore' and 'CAOverlapInterval' has to be equal if both are set"))
+	}
+	if o.CertRenewBefore != 0 && o.CertRenewBefore != o.CertOverlapInterval {
+		errs = append(errs, fmt.Errorf("'CertRenewBefore' and 'CertOverlapInterval' has to be equal if both are set"))
 	}
+	if o.RenewFraction != 0 && (o.CARenewBefore != 0 || o.CertRenewBefore != 0) {
+		errs = append(errs, fmt.Errorf("'RenewFraction' can not be used with 'CARenewBefore' or 'CertRenewBefore'"))
+	}
+	if o.RenewFraction < 0 || o.RenewFraction > 1 {
+		errs = append(errs, fmt.Errorf("'RenewFraction' has to be >= 0 and <= 1"))
+	}
+	return errs
+}
 
-	if o.WebhookType != MutatingWebhook && o.WebhookType != ValidatingWebhook {
-		return fmt.Errorf("failed validating certificate options, 'WebhookType' has to be %s or %s", MutatingWebhook, ValidatingWebhook)
+func (o *Options) validateCABundle() []error {
+	errs := []error{}
+	if o.CABundleSource != nil && o.ManageCABundle != nil && !*o.ManageCABundle {
+		errs = append(errs, fmt.Errorf("'CABundleSource' can not be used with 'ManageCABundle' false"))
+	}
+	if o.MonitorOnly && o.CABundleSource != nil {
+		errs = append(errs, fmt.Errorf("'MonitorOnly' can not be used with 'CABundleSource'"))
+	}
+	if o.HardCutover && o.ProbeServiceTLS {
+		errs = append(errs, fmt.Errorf("'ProbeServiceTLS' can not be used with 'HardCutover'"))
 	}
+	if o.HardCutover && o.ProbeBeforeCABundleCleanup {
+		errs = append(errs, fmt.Errorf("'ProbeBeforeCABundleCleanup' can not be used with 'HardCutover'"))
+	}
+	if o.CleanupAcknowledgements < 0 {
+		errs = append(errs, fmt.Errorf("'CleanupAcknowledgements' has to be >= 0"))
+	}
+	return errs
+}
 
-	return nil
+// validateSharedCASecret rejects the options writing per webhook data at the
+// CA secret, it's shared with other webhooks
+func (o *Options) validateSharedCASecret() []error {
+	errs := []error{}
+	if o.SharedCASecret == nil {
+		return errs
+	}
+	if o.SharedCASecret.Namespace == "" || o.SharedCASecret.Name == "" {
+		errs = append(errs, fmt.Errorf("'SharedCASecret' namespace and name are required"))
+	}
+	if o.CANamespace != "" {
+		errs = append(errs, fmt.Errorf("'SharedCASecret' can not be used with 'CANamespace'"))
+	}
+	if o.ManageCABundle != nil && !*o.ManageCABundle {
+		errs = append(errs, fmt.Errorf("'SharedCASecret' can not be used with 'ManageCABundle' false"))
+	}
+	if o.CACertInConfigMap || o.CATrustStore {
+		errs = append(errs, fmt.Errorf("'SharedCASecret' can not be used with 'CACertInConfigMap' or 'CATrustStore'"))
+	}
+	if o.CABundleSource != nil || o.MonitorOnly {
+		errs = append(errs, fmt.Errorf("'SharedCASecret' can not be used with 'CABundleSource' or 'MonitorOnly'"))
+	}
+	return errs
+}
+
+func (o *Options) validateSecrets() []error {
+	errs := []error{}
+	if o.SANOnlyCertificates && o.Issuer != nil {
+		errs = append(errs, fmt.Errorf("'SANOnlyCertificates' can not be used with 'Issuer'"))
+	}
+	if _, isIPsIssuer := o.Issuer.(ServiceIPsIssuer); o.ServiceIPSANs && o.Issuer != nil && !isIPsIssuer {
+		errs = append(errs, fmt.Errorf("'ServiceIPSANs' needs an 'Issuer' implementing ServiceIPsIssuer"))
+	}
+	if o.TLSCertChain != "" && o.TLSCertChain != TLSCertChainOverlap && o.TLSCertChain != TLSCertChainLeaf {
+		errs = append(errs, fmt.Errorf("'TLSCertChain' has to be %s or %s", TLSCertChainOverlap, TLSCertChainLeaf))
+	}
+	if o.PrivateKeyEncoding != "" && o.PrivateKeyEncoding != PrivateKeyEncodingPKCS1 && o.PrivateKeyEncoding != PrivateKeyEncodingPKCS8 {
+		errs = append(errs, fmt.Errorf("'PrivateKeyEncoding' has to be %s or %s", PrivateKeyEncodingPKCS1, PrivateKeyEncodingPKCS8))
+	}
+	if o.RotationHistoryLimit < 0 {
+		errs = append(errs, fmt.Errorf("'RotationHistoryLimit' has to be >= 0"))
+	}
+	if o.AdoptCASecret != nil && !o.AdoptExistingSecrets {
+		errs = append(errs, fmt.Errorf("'AdoptCASecret' can only be used with 'AdoptExistingSecrets'"))
+	}
+	for _, workload := range o.RestartWorkloads {
+		if err := workload.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("'RestartWorkloads' %s: %v", workload, err))
+		}
+	}
+	return errs
+}
+
+func (o *Options) validateController() []error {
+	errs := []error{}
+	if o.Service != nil {
+		if err := o.Service.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if o.MaxConcurrentReconciles < 0 {
+		errs = append(errs, fmt.Errorf("'MaxConcurrentReconciles' has to be >= 0"))
+	}
+	if o.CacheSyncTimeout < 0 {
+		errs = append(errs, fmt.Errorf("'CacheSyncTimeout' has to be >= 0"))
+	}
+	if o.SelfTestInterval < 0 {
+		errs = append(errs, fmt.Errorf("'SelfTestInterval' has to be >= 0"))
+	}
+	if o.NotificationThreshold < 0 {
+		errs = append(errs, fmt.Errorf("'NotificationThreshold' has to be >= 0"))
+	}
+	return errs
 }
 
 func (o *Options) withDefaults() Options {
@@ -99,7 +491,11 @@ func (o *Options) withDefaults() Options {
 	}
 
 	if o.CAOverlapInterval == 0 {
-		withDefaultsOptions.CAOverlapInterval = withDefaultsOptions.CARotateInterval
+		if o.CARenewBefore != 0 {
+			withDefaultsOptions.CAOverlapInterval = o.CARenewBefore
+		} else {
+			withDefaultsOptions.CAOverlapInterval = withDefaultsOptions.CARotateInterval
+		}
 	}
 
 	if o.CertRotateInterval == 0 {
@@ -107,7 +503,15 @@ func (o *Options) withDefaults() Options {
 	}
 
 	if o.CertOverlapInterval == 0 {
-		withDefaultsOptions.CertOverlapInterval = withDefaultsOptions.CertRotateInterval
+		if o.CertRenewBefore != 0 {
+			withDefaultsOptions.CertOverlapInterval = o.CertRenewBefore
+		} else {
+			withDefaultsOptions.CertOverlapInterval = withDefaultsOptions.CertRotateInterval
+		}
+	}
+
+	if o.CATrustStore && o.CATrustStorePassword == "" {
+		withDefaultsOptions.CATrustStorePassword = DefaultTrustStorePassword
 	}
 	return withDefaultsOptions
 }
@@ -17,13 +17,22 @@
 package certificate
 
 import (
+	"os"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/spf13/pflag"
+
+	"k8s.io/apimachinery/pkg/types"
 )
 
+// hostnamesOnlyIssuer is an Issuer not implementing ServiceIPsIssuer
+type hostnamesOnlyIssuer struct {
+	Issuer
+}
+
 var _ = Describe("Certificate Options", func() {
 	type setDefaultsAndValidateCase struct {
 		options         Options
@@ -169,6 +178,26 @@ var _ = Describe("Certificate Options", func() {
 			},
 			isValid: true,
 		}),
+		Entry("Overlap intervals have to default to RenewBefore values", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:       "MyNamespace",
+				WebhookName:     "MyWebhook",
+				CARenewBefore:   30 * 24 * time.Hour,
+				CertRenewBefore: 7 * 24 * time.Hour,
+			},
+			expectedOptions: Options{
+				Namespace:           "MyNamespace",
+				WebhookName:         "MyWebhook",
+				WebhookType:         MutatingWebhook,
+				CARotateInterval:    OneYearDuration,
+				CAOverlapInterval:   30 * 24 * time.Hour,
+				CertRotateInterval:  OneYearDuration,
+				CertOverlapInterval: 7 * 24 * time.Hour,
+				CARenewBefore:       30 * 24 * time.Hour,
+				CertRenewBefore:     7 * 24 * time.Hour,
+			},
+			isValid: true,
+		}),
 
 		Entry("Passing CAOverlapInterval > CARotateInterval should be invalid", setDefaultsAndValidateCase{
 			options: Options{
@@ -215,6 +244,205 @@ var _ = Describe("Certificate Options", func() {
 			},
 			isValid: false,
 		}),
+		Entry("Passing CARenewBefore different than CAOverlapInterval should be invalid", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:         "MyNamespace",
+				WebhookName:       "MyWebhook",
+				CAOverlapInterval: 2 * time.Hour,
+				CARenewBefore:     1 * time.Hour,
+			},
+			expectedOptions: Options{
+				Namespace:         "MyNamespace",
+				WebhookName:       "MyWebhook",
+				CAOverlapInterval: 2 * time.Hour,
+				CARenewBefore:     1 * time.Hour,
+			},
+			isValid: false,
+		}),
+		Entry("Passing negative MaxConcurrentReconciles should be invalid", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:               "MyNamespace",
+				WebhookName:             "MyWebhook",
+				MaxConcurrentReconciles: -1,
+			},
+			expectedOptions: Options{
+				Namespace:               "MyNamespace",
+				WebhookName:             "MyWebhook",
+				MaxConcurrentReconciles: -1,
+			},
+			isValid: false,
+		}),
+		Entry("Passing RenewFraction bigger than 1 should be invalid", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:     "MyNamespace",
+				WebhookName:   "MyWebhook",
+				RenewFraction: 1.5,
+			},
+			expectedOptions: Options{
+				Namespace:     "MyNamespace",
+				WebhookName:   "MyWebhook",
+				RenewFraction: 1.5,
+			},
+			isValid: false,
+		}),
+		Entry("Passing HardCutover with ProbeServiceTLS should be invalid", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:       "MyNamespace",
+				WebhookName:     "MyWebhook",
+				HardCutover:     true,
+				ProbeServiceTLS: true,
+			},
+			expectedOptions: Options{
+				Namespace:       "MyNamespace",
+				WebhookName:     "MyWebhook",
+				HardCutover:     true,
+				ProbeServiceTLS: true,
+			},
+			isValid: false,
+		}),
+		Entry("Passing ServiceIPSANs with an Issuer not implementing ServiceIPsIssuer should be invalid", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:     "MyNamespace",
+				WebhookName:   "MyWebhook",
+				Issuer:        hostnamesOnlyIssuer{Issuer: tripleIssuer{}},
+				ServiceIPSANs: true,
+			},
+			expectedOptions: Options{
+				Namespace:     "MyNamespace",
+				WebhookName:   "MyWebhook",
+				Issuer:        hostnamesOnlyIssuer{Issuer: tripleIssuer{}},
+				ServiceIPSANs: true,
+			},
+			isValid: false,
+		}),
+		Entry("Passing SANOnlyCertificates with Issuer should be invalid", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:           "MyNamespace",
+				WebhookName:         "MyWebhook",
+				Issuer:              tripleIssuer{},
+				SANOnlyCertificates: true,
+			},
+			expectedOptions: Options{
+				Namespace:           "MyNamespace",
+				WebhookName:         "MyWebhook",
+				Issuer:              tripleIssuer{},
+				SANOnlyCertificates: true,
+			},
+			isValid: false,
+		}),
+		Entry("Passing unknown TLSCertChain should be invalid", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:    "MyNamespace",
+				WebhookName:  "MyWebhook",
+				TLSCertChain: "LeafAndIntermediate",
+			},
+			expectedOptions: Options{
+				Namespace:    "MyNamespace",
+				WebhookName:  "MyWebhook",
+				TLSCertChain: "LeafAndIntermediate",
+			},
+			isValid: false,
+		}),
+		Entry("Passing unknown PrivateKeyEncoding should be invalid", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:          "MyNamespace",
+				WebhookName:        "MyWebhook",
+				PrivateKeyEncoding: "PKCS12",
+			},
+			expectedOptions: Options{
+				Namespace:          "MyNamespace",
+				WebhookName:        "MyWebhook",
+				PrivateKeyEncoding: "PKCS12",
+			},
+			isValid: false,
+		}),
+		Entry("Passing unknown FeatureGates should be invalid", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:    "MyNamespace",
+				WebhookName:  "MyWebhook",
+				FeatureGates: map[FeatureGate]bool{"HotTLSReload": true},
+			},
+			expectedOptions: Options{
+				Namespace:    "MyNamespace",
+				WebhookName:  "MyWebhook",
+				FeatureGates: map[FeatureGate]bool{"HotTLSReload": true},
+			},
+			isValid: false,
+		}),
+		Entry("Passing Service without Selector should be invalid", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:   "MyNamespace",
+				WebhookName: "MyWebhook",
+				Service:     &ServiceTemplate{TargetPort: 8443},
+			},
+			expectedOptions: Options{
+				Namespace:   "MyNamespace",
+				WebhookName: "MyWebhook",
+				Service:     &ServiceTemplate{TargetPort: 8443},
+			},
+			isValid: false,
+		}),
+		Entry("Passing AdoptCASecret without AdoptExistingSecrets should be invalid", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:     "MyNamespace",
+				WebhookName:   "MyWebhook",
+				AdoptCASecret: &types.NamespacedName{Namespace: "MyNamespace", Name: "external-ca"},
+			},
+			expectedOptions: Options{
+				Namespace:     "MyNamespace",
+				WebhookName:   "MyWebhook",
+				AdoptCASecret: &types.NamespacedName{Namespace: "MyNamespace", Name: "external-ca"},
+			},
+			isValid: false,
+		}),
+		Entry("Passing HardCutover with ProbeBeforeCABundleCleanup should be invalid", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:                  "MyNamespace",
+				WebhookName:                "MyWebhook",
+				HardCutover:                true,
+				ProbeBeforeCABundleCleanup: true,
+			},
+			expectedOptions: Options{
+				Namespace:                  "MyNamespace",
+				WebhookName:                "MyWebhook",
+				HardCutover:                true,
+				ProbeBeforeCABundleCleanup: true,
+			},
+			isValid: false,
+		}),
+		Entry("Passing RestartWorkloads with unknown kind should be invalid", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:        "MyNamespace",
+				WebhookName:      "MyWebhook",
+				RestartWorkloads: []Workload{{Kind: "Pod", Namespace: "MyNamespace", Name: "MyPod"}},
+			},
+			expectedOptions: Options{
+				Namespace:        "MyNamespace",
+				WebhookName:      "MyWebhook",
+				RestartWorkloads: []Workload{{Kind: "Pod", Namespace: "MyNamespace", Name: "MyPod"}},
+			},
+			isValid: false,
+		}),
+
+		Entry("CATrustStorePassword has to default to DefaultTrustStorePassword if CATrustStore is set", setDefaultsAndValidateCase{
+			options: Options{
+				Namespace:    "MyNamespace",
+				WebhookName:  "MyWebhook",
+				CATrustStore: true,
+			},
+			expectedOptions: Options{
+				Namespace:            "MyNamespace",
+				WebhookName:          "MyWebhook",
+				WebhookType:          MutatingWebhook,
+				CARotateInterval:     OneYearDuration,
+				CAOverlapInterval:    OneYearDuration,
+				CertRotateInterval:   OneYearDuration,
+				CertOverlapInterval:  OneYearDuration,
+				CATrustStore:         true,
+				CATrustStorePassword: DefaultTrustStorePassword,
+			},
+			isValid: true,
+		}),
 
 		Entry("Passing all options override defaults", setDefaultsAndValidateCase{
 			options: Options{
@@ -238,4 +466,122 @@ var _ = Describe("Certificate Options", func() {
 			isValid: true,
 		}),
 	)
+
+	Context("when several options are invalid", func() {
+		It("should return all the violations", func() {
+			options := Options{
+				WebhookType:             "Admission",
+				CARotateInterval:        time.Hour,
+				CertRotateInterval:      2 * time.Hour,
+				MaxConcurrentReconciles: -1,
+			}
+			err := options.setDefaultsAndValidate()
+			Expect(err).ToNot(Succeed(), "should not succeed validating the options")
+			Expect(err.Error()).To(ContainSubstring("'WebhookName' field is missing"))
+			Expect(err.Error()).To(ContainSubstring("'Namespace' field is missing"))
+			Expect(err.Error()).To(ContainSubstring("'WebhookType' has to be"))
+			Expect(err.Error()).To(ContainSubstring("'CertRotateInterval' has to be <= 'CARotateInterval'"))
+			Expect(err.Error()).To(ContainSubstring("'MaxConcurrentReconciles' has to be >= 0"))
+		})
+	})
+
+	type parseOptionsCase struct {
+		config          string
+		expectedOptions *Options
+	}
+	DescribeTable("ParseOptions",
+		func(c parseOptionsCase) {
+			options, err := ParseOptions([]byte(c.config))
+			if c.expectedOptions != nil {
+				Expect(err).To(Succeed(), "should succeed parsing the options")
+			} else {
+				Expect(err).ToNot(Succeed(), "should not succeed parsing the options")
+			}
+			Expect(options).To(Equal(c.expectedOptions), "should equal expected options without defaults")
+		},
+		Entry("YAML config should be parsed with durations", parseOptionsCase{
+			config: `
+webhookName: MyWebhook
+webhookType: Validating
+namespace: MyNamespace
+caRotateInterval: 1h
+certRotateInterval: 30m
+restartWorkloads:
+- kind: Deployment
+  namespace: MyNamespace
+  name: MyDeployment
+featureGates:
+  PreflightOnStart: true
+`,
+			expectedOptions: &Options{
+				WebhookName:        "MyWebhook",
+				WebhookType:        ValidatingWebhook,
+				Namespace:          "MyNamespace",
+				CARotateInterval:   time.Hour,
+				CertRotateInterval: 30 * time.Minute,
+				RestartWorkloads:   []Workload{{Kind: DeploymentWorkload, Namespace: "MyNamespace", Name: "MyDeployment"}},
+				FeatureGates:       map[FeatureGate]bool{FeatureGatePreflightOnStart: true},
+			},
+		}),
+		Entry("JSON config should be parsed", parseOptionsCase{
+			config: `{"webhookName": "MyWebhook", "namespace": "MyNamespace", "hardCutover": true}`,
+			expectedOptions: &Options{
+				WebhookName: "MyWebhook",
+				Namespace:   "MyNamespace",
+				HardCutover: true,
+			},
+		}),
+		Entry("Unknown field should be invalid", parseOptionsCase{
+			config: `{"webhookName": "MyWebhook", "namespace": "MyNamespace", "caRotationInterval": "1h"}`,
+		}),
+		Entry("Bad duration should be invalid", parseOptionsCase{
+			config: `{"webhookName": "MyWebhook", "namespace": "MyNamespace", "caRotateInterval": "1 year"}`,
+		}),
+		Entry("Invalid options should be invalid", parseOptionsCase{
+			config: `{"webhookName": "MyWebhook", "namespace": "MyNamespace", "caRotateInterval": "1h", "certRotateInterval": "2h"}`,
+		}),
+	)
+
+	Context("when AddFlags is called", func() {
+		var (
+			options Options
+			fs      *pflag.FlagSet
+		)
+		BeforeEach(func() {
+			options = Options{Namespace: "DefaultNamespace"}
+			fs = pflag.NewFlagSet("test", pflag.ContinueOnError)
+			options.AddFlags(fs)
+		})
+		AfterEach(func() {
+			Expect(os.Unsetenv(EnvPrefix + "WEBHOOK_NAME")).To(Succeed())
+			Expect(os.Unsetenv(EnvPrefix + "CA_ROTATE_INTERVAL")).To(Succeed())
+		})
+		It("should set the options from flags and environment", func() {
+			Expect(os.Setenv(EnvPrefix+"WEBHOOK_NAME", "EnvWebhook")).To(Succeed())
+			Expect(os.Setenv(EnvPrefix+"CA_ROTATE_INTERVAL", "2h")).To(Succeed())
+			err := fs.Parse([]string{
+				"--ca-rotate-interval=1h",
+				"--manage-ca-bundle=false",
+				"--shared-ca-secret=MyNamespace/MyCA",
+				"--restart-workloads=Deployment/MyNamespace/MyDeployment",
+				"--feature-gates=PreflightOnStart=true",
+			})
+			Expect(err).To(Succeed(), "should succeed parsing the flags")
+			Expect(SetFlagsFromEnv(fs)).To(Succeed(), "should succeed setting the flags from environment")
+
+			manageCABundle := false
+			Expect(options).To(Equal(Options{
+				WebhookName:      "EnvWebhook",
+				Namespace:        "DefaultNamespace",
+				CARotateInterval: time.Hour,
+				ManageCABundle:   &manageCABundle,
+				SharedCASecret:   &types.NamespacedName{Namespace: "MyNamespace", Name: "MyCA"},
+				RestartWorkloads: []Workload{{Kind: DeploymentWorkload, Namespace: "MyNamespace", Name: "MyDeployment"}},
+				FeatureGates:     map[FeatureGate]bool{FeatureGatePreflightOnStart: true},
+			}), "should prefer the flags over the environment")
+		})
+		It("should fail with bad values", func() {
+			Expect(fs.Parse([]string{"--shared-ca-secret=MyCA"})).ToNot(Succeed(), "should fail without namespace")
+		})
+	})
 })
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+var (
+	webhookConfigurationVerbs = []string{"get", "list", "watch", "update"}
+	readWriteVerbs            = []string{"get", "list", "watch", "create", "update"}
+)
+
+// withPreflight runs Preflight before starting runnable if
+// FeatureGatePreflightOnStart is enabled.
+func (m *Manager) withPreflight(runnable manager.Runnable) manager.Runnable {
+	if !m.featureEnabled(FeatureGatePreflightOnStart) {
+		return runnable
+	}
+	return manager.RunnableFunc(func(ctx context.Context) error {
+		err := m.Preflight(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed preflight checks")
+		}
+		return runnable.Start(ctx)
+	})
+}
+
+// Preflight checks, without waiting for them like Reconcile does, that the
+// webhook configuration exists, that the services it references exist and
+// that RBAC allows the Manager to read and write the objects it manages.
+// It returns all the problems found, so they can be fixed before starting
+// the Manager instead of reconciling nothing.
+func (m *Manager) Preflight(ctx context.Context) error {
+	errs := []error{}
+	secretNamespaces := map[string]bool{m.caSecretKey().Namespace: true}
+
+	webhookConf, err := m.emptyWebhookConfiguration()
+	if err != nil {
+		return err
+	}
+	err = m.get(ctx, types.NamespacedName{Name: m.webhookName}, webhookConf)
+	if err != nil {
+		errs = append(errs, errors.Wrapf(err, "failed getting %s webhook configuration %s", m.webhookType, m.webhookName))
+	} else {
+		errs = append(errs, m.preflightServices(ctx, webhookConf, secretNamespaces)...)
+	}
+
+	resource := strings.ToLower(string(m.webhookType)) + "webhookconfigurations"
+	errs = append(errs, m.preflightAccess(ctx, "admissionregistration.k8s.io", resource, "", webhookConfigurationVerbs)...)
+	for _, namespace := range sortedNamespaces(secretNamespaces) {
+		errs = append(errs, m.preflightAccess(ctx, "", "secrets", namespace, readWriteVerbs)...)
+	}
+	if m.caCertInConfigMap {
+		errs = append(errs, m.preflightAccess(ctx, "", "configmaps", m.caSecretKey().Namespace, readWriteVerbs)...)
+	}
+	if m.lockCARotation {
+		errs = append(errs, m.preflightAccess(ctx, "coordination.k8s.io", "leases", m.caRotationLeaseKey().Namespace,
+			[]string{"get", "create", "update"})...)
+	}
+
+	if len(errs) > 0 {
+		return errors.Wrapf(utilerrors.NewAggregate(errs), "failed preflight of %s webhook %s", m.webhookType, m.webhookName)
+	}
+	return nil
+}
+
+// preflightServices checks that the services referenced by the webhook
+// configuration exist and adds the namespaces of their secrets.
+func (m *Manager) preflightServices(ctx context.Context, webhookConf client.Object, secretNamespaces map[string]bool) []error {
+	errs := []error{}
+	for _, clientConfig := range m.clientConfigList(webhookConf) {
+		if clientConfig.Service == nil {
+			if clientConfig.URL == nil {
+				errs = append(errs, fmt.Errorf("webhook without serviceRef or URL"))
+			}
+			secretNamespaces[m.namespace] = true
+			continue
+		}
+		serviceKey := types.NamespacedName{Namespace: clientConfig.Service.Namespace, Name: clientConfig.Service.Name}
+		secretNamespaces[serviceKey.Namespace] = true
+		err := m.client.Get(ctx, serviceKey, &corev1.Service{})
+		if apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("service %s referenced by the webhook configuration not found", serviceKey))
+		} else if err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed getting service %s", serviceKey))
+		}
+	}
+	return errs
+}
+
+// preflightAccess checks with SelfSubjectAccessReviews that the Manager
+// is allowed to do verbs on resource at namespace.
+func (m *Manager) preflightAccess(ctx context.Context, group, resource, namespace string, verbs []string) []error {
+	errs := []error{}
+	for _, verb := range verbs {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Group:     group,
+					Resource:  resource,
+					Namespace: namespace,
+					Verb:      verb,
+				},
+			},
+		}
+		err := m.client.Create(ctx, review)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed reviewing access to %s %s", verb, resource))
+			continue
+		}
+		if !review.Status.Allowed {
+			errs = append(errs, fmt.Errorf("not allowed to %s %s at namespace %q: %s", verb, resource, namespace, review.Status.Reason))
+		}
+	}
+	return errs
+}
+
+func sortedNamespaces(namespaces map[string]bool) []string {
+	sorted := []string{}
+	for namespace := range namespaces {
+		sorted = append(sorted, namespace)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
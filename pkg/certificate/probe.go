@@ -0,0 +1,104 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate/triple"
+)
+
+const probeTimeout = 5 * time.Second
+
+// probeServedCertificate dials the webhook referenced by clientConfig and
+// verifies that the certificate it serves chains to caBundle, it's used to
+// not publish a CABundle that does not trust the certificate the webhook
+// pods are serving, for example if they still use a stale secret volume.
+func probeServedCertificate(ctx context.Context, clientConfig *admissionregistrationv1.WebhookClientConfig, caBundle []byte) error {
+	host, err := clientConfigHost(clientConfig)
+	if err != nil {
+		return err
+	}
+	serverName, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return errors.Wrapf(err, "failed parsing webhook host %s", host)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caBundle) {
+		return errors.New("CABundle has no certificates")
+	}
+
+	dialer := tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: probeTimeout},
+		Config:    &tls.Config{RootCAs: roots, ServerName: serverName, MinVersion: tls.VersionTLS12},
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return errors.Wrapf(err, "failed verifying certificate served at %s", host)
+	}
+	return conn.Close()
+}
+
+// postponeCABundleCleanupIfNotServing postpones the CABundle cleanup if
+// its deadline has passed and Options.ProbeBeforeCABundleCleanup is set
+// but the webhooks are not serving certificates issued by the current CA.
+func (m *Manager) postponeCABundleCleanupIfNotServing(ctx context.Context, elapsedForCleanup time.Duration) (time.Duration, error) {
+	if elapsedForCleanup > 0 || !m.probeBeforeCABundleCleanup {
+		return elapsedForCleanup, nil
+	}
+	serving, err := m.servingCurrentCA(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !serving {
+		return postponeCleanup(elapsedForCleanup), nil
+	}
+	return elapsedForCleanup, nil
+}
+
+// servingCurrentCA probes every webhook trusting only the current CA, the
+// ones still serving a certificate issued by a previous CA would fail
+// once it's removed from the CABundle.
+func (m *Manager) servingCurrentCA(ctx context.Context) (bool, error) {
+	caKeyPair, err := m.getCAKeyPair(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "failed getting CA key pair")
+	}
+	webhook, err := m.readyWebhookConfiguration(ctx)
+	if err != nil {
+		return false, err
+	}
+	caPEM := triple.EncodeCertPEM(caKeyPair.Cert)
+	for _, clientConfig := range m.clientConfigList(webhook) {
+		err = probeServedCertificate(ctx, clientConfig, caPEM)
+		if err != nil {
+			m.log.Info(fmt.Sprintf("Postponing CABundle cleanup, webhook is not serving a certificate issued by the current CA: %v", err))
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,40 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"net/http"
+)
+
+// ReadyzCheck is a healthz.Checker that fails until the managed
+// certificates exist and verify against the webhook configuration
+// CABundle, it can be added with the controller-runtime
+// manager.AddReadyzCheck so the webhook is not ready before its
+// certificates are.
+func (m *Manager) ReadyzCheck(req *http.Request) error {
+	return m.Verify(req.Context())
+}
+
+// Verify checks that the managed certificates exist and verify against
+// the webhook configuration CABundle.
+func (m *Manager) Verify(ctx context.Context) error {
+	if m.caBundleSource != nil {
+		return m.verifyServicesTLSSecrets(ctx)
+	}
+	return m.verifyTLS(ctx)
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// chainSnapshot is the certificate chain state read before a rotation so
+// it can be restored if the rotation fails partway, objects not present
+// before the rotation are not part of it.
+type chainSnapshot struct {
+	caBundles  [][]byte
+	secrets    []*corev1.Secret
+	configMaps []*corev1.ConfigMap
+}
+
+// rotateWithRollback calls rotateFn and restores the chain state read
+// before it if it fails, so a half rotated chain is not left behind
+// until the next Reconcile succeeds.
+func (m *Manager) rotateWithRollback(ctx context.Context, rotateFn func(*Manager, context.Context) error) error {
+	snapshot, err := m.takeChainSnapshot(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed reading certificate chain before rotation")
+	}
+
+	return m.withRelaxedFailurePolicy(ctx, func() error {
+		err = rotateFn(m, ctx)
+		if err != nil {
+			m.log.Info(fmt.Sprintf("Rotation failed, rolling back certificate chain: %v", err))
+			rollbackErr := m.restoreChainSnapshot(ctx, snapshot)
+			if rollbackErr != nil {
+				return errors.Wrapf(err, "failed rolling back certificate chain: %v, after rotation error", rollbackErr)
+			}
+			m.audit(ctx, AuditActionRollback, fmt.Sprintf("rotation failed: %v", err), m.chainObjects(ctx)...)
+			return err
+		}
+		return nil
+	})
+}
+
+func (m *Manager) takeChainSnapshot(ctx context.Context) (*chainSnapshot, error) {
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed reading webhook configuration")
+	}
+
+	snapshot := &chainSnapshot{}
+	for _, clientConfig := range m.clientConfigList(webhookConf) {
+		snapshot.caBundles = append(snapshot.caBundles, clientConfig.CABundle)
+	}
+
+	services, err := m.getServicesFromConfiguration(webhookConf)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed retrieving services from clientConfig")
+	}
+	// The shared CA secret is not restored since other Managers may
+	// already be using the rotated CA
+	secretKeys := sortedServices(services)
+	if m.sharedCASecret == nil {
+		secretKeys = append([]types.NamespacedName{m.caSecretKey()}, secretKeys...)
+	}
+	for _, secretKey := range secretKeys {
+		secret := &corev1.Secret{}
+		err = m.get(ctx, secretKey, secret)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed reading secret %s", secretKey)
+		}
+		snapshot.secrets = append(snapshot.secrets, secret)
+	}
+
+	if m.caCertInConfigMap {
+		configMap := &corev1.ConfigMap{}
+		err = m.get(ctx, m.caConfigMapKey(), configMap)
+		if err == nil {
+			snapshot.configMaps = append(snapshot.configMaps, configMap)
+		} else if !apierrors.IsNotFound(err) {
+			return nil, errors.Wrapf(err, "failed reading configmap %s", m.caConfigMapKey())
+		}
+	}
+	return snapshot, nil
+}
+
+// restoreChainSnapshot writes back the secrets and configmaps first and the
+// CABundle last, the CABundle written by the failed rotation still trusts
+// the previous CA so the webhook keeps working meanwhile.
+func (m *Manager) restoreChainSnapshot(ctx context.Context, snapshot *chainSnapshot) error {
+	for _, secret := range snapshot.secrets {
+		err := m.restoreSecret(ctx, secret)
+		if err != nil {
+			return errors.Wrapf(err, "failed restoring secret %s/%s", secret.Namespace, secret.Name)
+		}
+	}
+
+	for _, configMap := range snapshot.configMaps {
+		previous := configMap
+		err := m.applyConfigMap(ctx, client.ObjectKeyFromObject(previous), func(current *corev1.ConfigMap) {
+			current.Annotations = previous.Annotations
+			current.Labels = previous.Labels
+			current.Data = previous.Data
+			current.BinaryData = previous.BinaryData
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed restoring configmap %s/%s", previous.Namespace, previous.Name)
+		}
+	}
+
+	// The CABundle is at the CA secret, it's already restored
+	if !m.manageCABundle {
+		return nil
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		webhookConf, err := m.readyWebhookConfiguration(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed reading webhook configuration")
+		}
+		clientConfigs := m.clientConfigList(webhookConf)
+		if len(clientConfigs) != len(snapshot.caBundles) {
+			return errors.New("webhook configuration changed during rotation, not restoring CABundle")
+		}
+		for i, clientConfig := range clientConfigs {
+			clientConfig.CABundle = snapshot.caBundles[i]
+		}
+		return m.client.Update(ctx, webhookConf)
+	})
+}
+
+func (m *Manager) restoreSecret(ctx context.Context, previous *corev1.Secret) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		current := &corev1.Secret{}
+		err := m.get(ctx, client.ObjectKeyFromObject(previous), current)
+		if err != nil {
+			return err
+		}
+		current.Annotations = previous.Annotations
+		current.Labels = previous.Labels
+		current.Data = previous.Data
+		return m.storage.Update(ctx, current)
+	})
+}
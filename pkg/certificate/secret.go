@@ -20,7 +20,6 @@ import (
 	"context"
 	"crypto/rsa"
 	"crypto/x509"
-	"reflect"
 
 	"github.com/pkg/errors"
 
@@ -38,13 +37,13 @@ const (
 	secretManagedAnnotatoinKey = "kubevirt.io/kube-admission-webhook"
 	CACertKey                  = "ca.crt"
 	CAPrivateKeyKey            = "ca.key"
+	// CABundleKey is the CA secret key where the CABundle is kept if
+	// Options.ManageCABundle is false
+	CABundleKey = "ca-bundle.crt"
 )
 
 func populateCASecret(secret *corev1.Secret, keyPair *triple.KeyPair) (*corev1.Secret, error) {
-	if secret.Annotations == nil {
-		secret.Annotations = map[string]string{}
-	}
-	secret.Annotations[secretManagedAnnotatoinKey] = ""
+	setAnnotation(secret)
 	secret.Data = map[string][]byte{
 		CACertKey:       triple.EncodeCertPEM(keyPair.Cert),
 		CAPrivateKeyKey: triple.EncodePrivateKeyPEM(keyPair.Key),
@@ -52,6 +51,16 @@ func populateCASecret(secret *corev1.Secret, keyPair *triple.KeyPair) (*corev1.S
 	return secret, nil
 }
 
+// populateCAPrivateKeySecret is used when the CA certificate is stored at a
+// ConfigMap so the CA secret only contains the private key
+func populateCAPrivateKeySecret(secret *corev1.Secret, keyPair *triple.KeyPair) (*corev1.Secret, error) {
+	setAnnotation(secret)
+	secret.Data = map[string][]byte{
+		CAPrivateKeyKey: triple.EncodePrivateKeyPEM(keyPair.Key),
+	}
+	return secret, nil
+}
+
 func addTLSCertificate(data map[string][]byte, cert *x509.Certificate) error {
 	certsPEM, hasCerts := data[corev1.TLSCertKey]
 	if hasCerts {
@@ -72,6 +81,7 @@ func setAnnotation(secret *corev1.Secret) {
 		secret.Annotations = map[string]string{}
 	}
 	secret.Annotations[secretManagedAnnotatoinKey] = ""
+	setManagedLabel(secret)
 }
 
 func resetTLSSecret(secret *corev1.Secret, keyPair *triple.KeyPair) (*corev1.Secret, error) {
@@ -101,24 +111,132 @@ func appendTLSSecret(secret *corev1.Secret, keyPair *triple.KeyPair) (*corev1.Se
 	return secret, nil
 }
 
-func (m *Manager) resetAndApplyTLSSecret(secret types.NamespacedName, keyPair *triple.KeyPair) error {
-	return m.applySecret(secret, corev1.SecretTypeTLS, keyPair, resetTLSSecret)
+func (m *Manager) resetAndApplyTLSSecret(ctx context.Context, secret types.NamespacedName, keyPair *triple.KeyPair) error {
+	return m.applyTLSSecret(ctx, secret, keyPair, resetTLSSecret)
+}
+
+func (m *Manager) appendAndApplyTLSSecret(ctx context.Context, secret types.NamespacedName, keyPair *triple.KeyPair) error {
+	return m.applyTLSSecret(ctx, secret, keyPair, appendTLSSecret)
+}
+
+// applyTLSSecret populates the TLS secret with populateSecretFn and adds
+// the webhook CABundle at the "ca.crt" key like cert-manager does, so
+// clients mounting the secret can establish trust without the CA secret.
+func (m *Manager) applyTLSSecret(ctx context.Context, secretKey types.NamespacedName, keyPair *triple.KeyPair,
+	populateSecretFn func(*corev1.Secret, *triple.KeyPair) (*corev1.Secret, error)) error {
+	caBundle, err := m.CABundleWithContext(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed getting CABundle for TLS secret")
+	}
+	return m.applySecret(ctx, secretKey, corev1.SecretTypeTLS, keyPair,
+		func(secret *corev1.Secret, keyPair *triple.KeyPair) (*corev1.Secret, error) {
+			populatedSecret, err := populateSecretFn(secret, keyPair)
+			if err != nil {
+				return nil, err
+			}
+			populatedSecret.Data[corev1.TLSCertKey], err = m.tlsCertsPEM(populatedSecret.Data[corev1.TLSCertKey], keyPair.Cert, caBundle)
+			if err != nil {
+				return nil, err
+			}
+			populatedSecret.Data[CACertKey] = caBundle
+			m.setCertManagerAnnotations(populatedSecret, keyPair.Cert)
+			return populatedSecret, nil
+		})
+}
+
+// applyCABundleToTLSSecrets refresh the "ca.crt" key of the services TLS
+// secrets after the CABundle has changed
+func (m *Manager) applyCABundleToTLSSecrets(ctx context.Context) error {
+	caBundle, err := m.CABundleWithContext(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed getting CABundle for TLS secrets")
+	}
+
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed getting webhook configuration")
+	}
+
+	services, err := m.getServicesFromConfiguration(webhookConf)
+	if err != nil {
+		return errors.Wrap(err, "failed getting services")
+	}
+
+	for service := range services {
+		err = m.applySecret(ctx, service, corev1.SecretTypeTLS, nil,
+			func(secret *corev1.Secret, _ *triple.KeyPair) (*corev1.Secret, error) {
+				if secret.Data == nil {
+					secret.Data = map[string][]byte{}
+				}
+				secret.Data[CACertKey] = caBundle
+				return secret, nil
+			})
+		if err != nil {
+			return errors.Wrapf(err, "failed applying CABundle to TLS secret %s", service)
+		}
+	}
+	return nil
 }
 
-func (m *Manager) appendAndApplyTLSSecret(secret types.NamespacedName, keyPair *triple.KeyPair) error {
-	return m.applySecret(secret, corev1.SecretTypeTLS, keyPair, appendTLSSecret)
+func (m *Manager) applyCASecret(ctx context.Context, keyPair *triple.KeyPair) error {
+	populateSecretFn := populateCASecret
+	if m.caCertInConfigMap {
+		err := m.applyCAConfigMap(ctx, keyPair.Cert)
+		if err != nil {
+			return errors.Wrap(err, "failed storing CA cert at configmap")
+		}
+		populateSecretFn = populateCAPrivateKeySecret
+	}
+	return m.applySecret(ctx, m.caSecretKey(), corev1.SecretTypeOpaque, keyPair,
+		func(secret *corev1.Secret, keyPair *triple.KeyPair) (*corev1.Secret, error) {
+			caBundle, hasCABundle := secret.Data[CABundleKey]
+			trustStore, hasTrustStore := secret.Data[CATrustStoreKey]
+			populatedSecret, err := populateSecretFn(secret, keyPair)
+			if err != nil {
+				return nil, err
+			}
+			if hasCABundle {
+				populatedSecret.Data[CABundleKey] = caBundle
+			}
+			if hasTrustStore {
+				populatedSecret.Data[CATrustStoreKey] = trustStore
+			}
+			m.setCertManagerAnnotations(populatedSecret, keyPair.Cert)
+			return populatedSecret, nil
+		})
 }
 
-func (m *Manager) applyCASecret(keyPair *triple.KeyPair) error {
-	return m.applySecret(m.caSecretKey(), corev1.SecretTypeOpaque, keyPair, populateCASecret)
+// updateCASecretCABundleWithFunc is updateWebhookCABundleWithFunc for
+// Options.ManageCABundle false, the CABundle is kept at the CA secret
+// CABundleKey instead of the webhook configuration.
+func (m *Manager) updateCASecretCABundleWithFunc(ctx context.Context, updateCABundle func([]byte) ([]byte, error)) error {
+	m.log.Info("Updating CA bundle at CA secret")
+	err := m.applySecret(ctx, m.caSecretKey(), corev1.SecretTypeOpaque, nil,
+		func(secret *corev1.Secret, _ *triple.KeyPair) (*corev1.Secret, error) {
+			caBundle, err := updateCABundle(secret.Data[CABundleKey])
+			if err != nil {
+				return nil, errors.Wrap(err, "failed updating CA bundle")
+			}
+			setAnnotation(secret)
+			if secret.Data == nil {
+				secret.Data = map[string][]byte{}
+			}
+			secret.Data[CABundleKey] = caBundle
+			return secret, nil
+		})
+	if err != nil {
+		return errors.Wrap(err, "failed to update CA secret CABundle")
+	}
+	return nil
 }
 
-func (m *Manager) applySecret(secretKey types.NamespacedName, secretType corev1.SecretType, keyPair *triple.KeyPair,
+func (m *Manager) applySecret(ctx context.Context, secretKey types.NamespacedName, secretType corev1.SecretType, keyPair *triple.KeyPair,
 	populateSecretFn func(*corev1.Secret, *triple.KeyPair) (*corev1.Secret, error)) error {
 	secret := &corev1.Secret{}
+	populateSecretFn = m.withPrivateKeyEncoding(populateSecretFn)
 
 	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
-		err := m.get(secretKey, secret)
+		err := m.get(ctx, secretKey, secret)
 		if err != nil {
 			if apierrors.IsNotFound(err) {
 				newSecret := &corev1.Secret{
@@ -126,7 +244,6 @@ func (m *Manager) applySecret(secretKey types.NamespacedName, secretType corev1.
 						Name:        secretKey.Name,
 						Namespace:   secretKey.Namespace,
 						Annotations: map[string]string{},
-						Labels:      m.extraLabels,
 					},
 					Type: secretType,
 				}
@@ -135,7 +252,12 @@ func (m *Manager) applySecret(secretKey types.NamespacedName, secretType corev1.
 				if err != nil {
 					return errors.Wrap(err, "failed populating secret")
 				}
-				err = m.client.Create(context.TODO(), populatedSecret)
+				m.setExtraMetadata(populatedSecret)
+				m.setSecretOwner(populatedSecret)
+				if keyPair != nil {
+					m.recordRotation(populatedSecret, keyPair.Cert)
+				}
+				err = m.storage.Create(ctx, populatedSecret)
 				if err != nil {
 					return errors.Wrap(err, "failed creating secret")
 				}
@@ -148,7 +270,12 @@ func (m *Manager) applySecret(secretKey types.NamespacedName, secretType corev1.
 		if err != nil {
 			return errors.Wrap(err, "failed populating secret")
 		}
-		err = m.client.Update(context.TODO(), populatedSecret)
+		m.setExtraMetadata(populatedSecret)
+		m.setSecretOwner(populatedSecret)
+		if keyPair != nil {
+			m.recordRotation(populatedSecret, keyPair.Cert)
+		}
+		err = m.storage.Update(ctx, populatedSecret)
 		if err != nil {
 			return errors.Wrap(err, "failed updating secret")
 		}
@@ -158,21 +285,26 @@ func (m *Manager) applySecret(secretKey types.NamespacedName, secretType corev1.
 
 // verifyTLSSecret will verify that the caBundle and Secret are valid and can
 // be used to verify
-func (m *Manager) verifyTLSSecret(secretKey types.NamespacedName, caKeyPair *triple.KeyPair, caBundle []byte) error {
+func (m *Manager) verifyTLSSecret(ctx context.Context, secretKey types.NamespacedName, caKeyPair *triple.KeyPair, caBundle []byte) error {
 	secret := corev1.Secret{}
-	err := m.get(secretKey, &secret)
+	err := m.get(ctx, secretKey, &secret)
 	if err != nil {
 		return errors.Wrapf(err, "failed getting TLS secret %s", secretKey)
 	}
+	return m.verifyTLSSecretData(secretKey, &secret, caKeyPair, caBundle)
+}
 
+// verifyTLSSecretData is verifyTLSSecret with an already read secret
+func (m *Manager) verifyTLSSecretData(secretKey types.NamespacedName, secret *corev1.Secret,
+	caKeyPair *triple.KeyPair, caBundle []byte) error {
 	keyPEM, found := secret.Data[corev1.TLSPrivateKeyKey]
 	if !found {
-		return errors.New("TLS key not found")
+		return errors.Errorf("TLS key not found at secret %s field %s", secretKey, corev1.TLSPrivateKeyKey)
 	}
 
 	certsPEM, found := secret.Data[corev1.TLSCertKey]
 	if !found {
-		return errors.New("TLS certs not found")
+		return errors.Errorf("TLS certs not found at secret %s field %s", secretKey, corev1.TLSCertKey)
 	}
 
 	certsFromCABundle, err := triple.ParseCertsPEM(caBundle)
@@ -184,13 +316,31 @@ func (m *Manager) verifyTLSSecret(secretKey types.NamespacedName, caKeyPair *tri
 		return errors.New("CA bundle has no certificates")
 	}
 
-	lastCertFromCABundle := getFirstCert(certsFromCABundle)
+	key, err := triple.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return errors.Wrapf(err, "failed parsing TLS key at secret %s field %s", secretKey, corev1.TLSPrivateKeyKey)
+	}
+	if _, isRSA := key.(*rsa.PrivateKey); !isRSA {
+		return errors.Errorf("TLS key at secret %s field %s is not RSA", secretKey, corev1.TLSPrivateKeyKey)
+	}
 
-	if !reflect.DeepEqual(*lastCertFromCABundle, *caKeyPair.Cert) {
-		return errors.New("CA bundle and CA secret certificate are different")
+	certs, err := triple.ParseCertsPEM(certsPEM)
+	if err != nil {
+		return errors.Wrapf(err, "failed parsing TLS certs at secret %s field %s", secretKey, corev1.TLSCertKey)
 	}
 
-	err = triple.VerifyTLS(certsPEM, keyPEM, caBundle)
+	// Look for the issuer by AKI/SKI since other tools can reorder the
+	// CA bundle
+	issuer := triple.FindIssuer(getFirstCert(certs), certsFromCABundle)
+	if issuer == nil {
+		return errors.New("CA bundle has no issuer for the TLS certificate")
+	}
+
+	if !issuer.Equal(caKeyPair.Cert) {
+		return errors.New("TLS certificate issuer at CA bundle and CA secret certificate are different")
+	}
+
+	err = triple.VerifyTLSAt(certsPEM, keyPEM, caBundle, m.now())
 	if err != nil {
 		return errors.Wrapf(err, "failed verifying TLS from server Secret %s", secretKey)
 	}
@@ -198,9 +348,9 @@ func (m *Manager) verifyTLSSecret(secretKey types.NamespacedName, caKeyPair *tri
 	return nil
 }
 
-func (m *Manager) getCAKeyPair() (*triple.KeyPair, error) {
+func (m *Manager) getCAKeyPair(ctx context.Context) (*triple.KeyPair, error) {
 	caSecret := corev1.Secret{}
-	err := m.get(m.caSecretKey(), &caSecret)
+	err := m.get(ctx, m.caSecretKey(), &caSecret)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed reading ca secret %s", m.caSecretKey())
 	}
@@ -210,26 +360,38 @@ func (m *Manager) getCAKeyPair() (*triple.KeyPair, error) {
 		return nil, errors.Wrapf(err, "ca private key not found at secret %s", m.caSecretKey())
 	}
 
-	caCertPEM, found := caSecret.Data[CACertKey]
-	if !found {
-		return nil, errors.Wrapf(err, "ca cert not found at secret %s", m.caSecretKey())
+	var caCertPEM []byte
+	if m.caCertInConfigMap {
+		caCertPEM, err = m.getCACertPEMFromConfigMap(ctx)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		caCertPEM, found = caSecret.Data[CACertKey]
+		if !found {
+			return nil, errors.Wrapf(err, "ca cert not found at secret %s", m.caSecretKey())
+		}
 	}
 
 	caCerts, err := triple.ParseCertsPEM(caCertPEM)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed parsing ca cert PEM at secret %s", m.caSecretKey())
+		return nil, errors.Wrapf(err, "failed parsing ca cert PEM at secret %s field %s", m.caSecretKey(), CACertKey)
 	}
 
 	caPrivateKey, err := triple.ParsePrivateKeyPEM(caPrivateKeyPEM)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed parsing ca private key PEM at secret %s", m.caSecretKey())
+		return nil, errors.Wrapf(err, "failed parsing ca private key PEM at secret %s field %s", m.caSecretKey(), CAPrivateKeyKey)
 	}
-	return &triple.KeyPair{Key: caPrivateKey.(*rsa.PrivateKey), Cert: caCerts[0]}, nil
+	caRSAPrivateKey, isRSA := caPrivateKey.(*rsa.PrivateKey)
+	if !isRSA {
+		return nil, errors.Errorf("ca private key at secret %s field %s is not RSA", m.caSecretKey(), CAPrivateKeyKey)
+	}
+	return &triple.KeyPair{Key: caRSAPrivateKey, Cert: caCerts[0]}, nil
 }
 
-func (m *Manager) getTLSKeyPair(secretKey types.NamespacedName) (*triple.KeyPair, error) {
+func (m *Manager) getTLSKeyPair(ctx context.Context, secretKey types.NamespacedName) (*triple.KeyPair, error) {
 	secret := corev1.Secret{}
-	err := m.get(secretKey, &secret)
+	err := m.get(ctx, secretKey, &secret)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed reading ca secret %s", secretKey)
 	}
@@ -246,22 +408,27 @@ func (m *Manager) getTLSKeyPair(secretKey types.NamespacedName) (*triple.KeyPair
 
 	certs, err := triple.ParseCertsPEM(certPEM)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed parsing TLS cert PEM at secret %s", secretKey)
+		return nil, errors.Wrapf(err, "failed parsing TLS cert PEM at secret %s field %s", secretKey, corev1.TLSCertKey)
 	}
 
 	privateKey, err := triple.ParsePrivateKeyPEM(privateKeyPEM)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed parsing TLS private key PEM at secret %s", secretKey)
+		return nil, errors.Wrapf(err, "failed parsing TLS private key PEM at secret %s field %s", secretKey, corev1.TLSPrivateKeyKey)
+	}
+
+	rsaPrivateKey, isRSA := privateKey.(*rsa.PrivateKey)
+	if !isRSA {
+		return nil, errors.Errorf("TLS private key at secret %s field %s is not RSA", secretKey, corev1.TLSPrivateKeyKey)
 	}
 
 	lastPrependedCert := getFirstCert(certs)
 
-	return &triple.KeyPair{Key: privateKey.(*rsa.PrivateKey), Cert: lastPrependedCert}, nil
+	return &triple.KeyPair{Key: rsaPrivateKey, Cert: lastPrependedCert}, nil
 }
 
-func (m *Manager) getTLSCerts(secretKey types.NamespacedName) ([]*x509.Certificate, error) {
+func (m *Manager) getTLSCerts(ctx context.Context, secretKey types.NamespacedName) ([]*x509.Certificate, error) {
 	secret := corev1.Secret{}
-	err := m.get(secretKey, &secret)
+	err := m.get(ctx, secretKey, &secret)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed reading ca secret %s", secretKey)
 	}
@@ -273,14 +440,17 @@ func (m *Manager) getTLSCerts(secretKey types.NamespacedName) ([]*x509.Certifica
 
 	certs, err := triple.ParseCertsPEM(certPEM)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed parsing TLS cert PEM at secret %s", secretKey)
+		return nil, errors.Wrapf(err, "failed parsing TLS cert PEM at secret %s field %s", secretKey, corev1.TLSCertKey)
 	}
-	return certs, nil
+	return leafCerts(certs), nil
 }
 
 // FIXME: Is this default/webhookname good key for ca secret
 func (m *Manager) caSecretKey() types.NamespacedName {
-	return types.NamespacedName{Namespace: m.namespace, Name: m.webhookName + "-ca"}
+	if m.sharedCASecret != nil {
+		return *m.sharedCASecret
+	}
+	return types.NamespacedName{Namespace: m.caNamespace, Name: m.webhookName + "-ca"}
 }
 
 // Certs are prepended to implement overlap so we take the first one
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// SelfTestPath is where SelfTestHandler has to be registered at the
+	// webhook server
+	SelfTestPath = "/selftest"
+
+	selfTestTimeout    = 10 * time.Second
+	defaultServicePort = 443
+)
+
+// SelfTestHandler answers the AdmissionReviews sent by the Manager self test
+// allowing them, it has to be registered at SelfTestPath of the webhook
+// server so the self test goes through the same Service, DNS and TLS
+// serving certificate as the real admission requests.
+func SelfTestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		review := admissionv1.AdmissionReview{}
+		err := json.NewDecoder(r.Body).Decode(&review)
+		if err != nil || review.Request == nil {
+			http.Error(w, "failed decoding self test AdmissionReview", http.StatusBadRequest)
+			return
+		}
+		review.Response = &admissionv1.AdmissionResponse{
+			UID:     review.Request.UID,
+			Allowed: true,
+		}
+		review.Request = nil
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(review)
+	})
+}
+
+// SelfTestCheck is a healthz.Checker that fails if the last self test
+// failed or has not run yet, it always succeeds if Options.SelfTestInterval
+// is not set.
+func (m *Manager) SelfTestCheck(_ *http.Request) error {
+	if m.selfTestInterval == 0 {
+		return nil
+	}
+	m.selfTestMutex.Lock()
+	defer m.selfTestMutex.Unlock()
+	return m.selfTestErr
+}
+
+// runSelfTest sends a self test AdmissionReview every selfTestInterval until
+// ctx is done, the result is served by SelfTestCheck and metrics.
+func (m *Manager) runSelfTest(ctx context.Context) error {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		err := m.selfTest(ctx)
+		if err != nil {
+			m.log.Info(fmt.Sprintf("Webhook self test failed: %v", err))
+		}
+		m.recordSelfTest(err)
+	}, m.selfTestInterval)
+	return nil
+}
+
+func (m *Manager) recordSelfTest(err error) {
+	m.selfTestMutex.Lock()
+	m.selfTestErr = err
+	m.selfTestMutex.Unlock()
+
+	success := 1.0
+	if err != nil {
+		success = 0
+	}
+	selfTestSuccess.WithLabelValues(m.webhookName, string(m.webhookType)).Set(success)
+}
+
+// selfTest sends an AdmissionReview to every webhook of the configuration
+// trusting only its CABundle, so it fails if the CABundle, the secret and
+// the certificate served by the webhook pods do not line up.
+func (m *Manager) selfTest(ctx context.Context) error {
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed reading webhook configuration for self test")
+	}
+	for _, clientConfig := range m.clientConfigList(webhookConf) {
+		selfTestURL, err := clientConfigURL(clientConfig, SelfTestPath)
+		if err != nil {
+			return err
+		}
+		err = sendSelfTestReview(ctx, selfTestURL, clientConfig.CABundle)
+		if err != nil {
+			return errors.Wrapf(err, "failed self test at %s", selfTestURL)
+		}
+	}
+	return nil
+}
+
+func sendSelfTestReview(ctx context.Context, selfTestURL string, caBundle []byte) error {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caBundle) {
+		return errors.New("webhook CABundle has no certificates")
+	}
+	httpClient := http.Client{
+		Timeout: selfTestTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+		},
+	}
+
+	uid := types.UID(fmt.Sprintf("selftest-%d", time.Now().UnixNano()))
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: admissionv1.SchemeGroupVersion.String(), Kind: "AdmissionReview"},
+		Request:  &admissionv1.AdmissionRequest{UID: uid, Operation: admissionv1.Connect},
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		return errors.Wrap(err, "failed encoding AdmissionReview")
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, selfTestURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed creating self test request")
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return errors.Wrap(err, "failed sending AdmissionReview")
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status code %d", response.StatusCode)
+	}
+	responseReview := admissionv1.AdmissionReview{}
+	err = json.NewDecoder(response.Body).Decode(&responseReview)
+	if err != nil {
+		return errors.Wrap(err, "failed decoding AdmissionReview response")
+	}
+	if responseReview.Response == nil || responseReview.Response.UID != uid || !responseReview.Response.Allowed {
+		return errors.New("unexpected AdmissionReview response")
+	}
+	return nil
+}
+
+// clientConfigURL returns the https URL at path for the service or URL
+// referenced by clientConfig.
+func clientConfigURL(clientConfig *admissionregistrationv1.WebhookClientConfig, path string) (string, error) {
+	host, err := clientConfigHost(clientConfig)
+	if err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "https", Host: host, Path: path}).String(), nil
+}
+
+// clientConfigHost returns the host:port the apiserver dials for
+// clientConfig, for services it's ${service.Name}.${service.Namespace}.svc
+func clientConfigHost(clientConfig *admissionregistrationv1.WebhookClientConfig) (string, error) {
+	if clientConfig.Service != nil {
+		port := defaultServicePort
+		if clientConfig.Service.Port != nil {
+			port = int(*clientConfig.Service.Port)
+		}
+		host := fmt.Sprintf("%s.%s.svc", clientConfig.Service.Name, clientConfig.Service.Namespace)
+		return net.JoinHostPort(host, strconv.Itoa(port)), nil
+	}
+	if clientConfig.URL != nil {
+		u, err := url.Parse(*clientConfig.URL)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed parsing webhook URL %s", *clientConfig.URL)
+		}
+		if u.Port() == "" {
+			return net.JoinHostPort(u.Hostname(), strconv.Itoa(defaultServicePort)), nil
+		}
+		return u.Host, nil
+	}
+	return "", errors.New("bad configuration, webhook without serviceRef or URL")
+}
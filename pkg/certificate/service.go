@@ -0,0 +1,139 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/util/retry"
+)
+
+// ServiceTemplate describes the Services created by the Manager, with
+// Options.Service, for the webhook configuration serviceRefs, their name,
+// namespace and port are the serviceRef ones.
+type ServiceTemplate struct {
+	// Selector selects the webhook server pods
+	Selector map[string]string
+
+	// TargetPort is the webhook server pods port, if not set the serviceRef
+	// port is used
+	TargetPort int32
+}
+
+func (t *ServiceTemplate) validate() error {
+	if len(t.Selector) == 0 {
+		return fmt.Errorf("'Service' needs a 'Selector'")
+	}
+	if t.TargetPort < 0 {
+		return fmt.Errorf("'Service' 'TargetPort' has to be >= 0")
+	}
+	return nil
+}
+
+// applyServices creates or updates the Services referenced by the webhook
+// configuration following Options.Service, the fields not set by it, like
+// the ClusterIP, are kept.
+func (m *Manager) applyServices(ctx context.Context) error {
+	if m.service == nil {
+		return nil
+	}
+
+	webhookConf, err := m.readyWebhookConfiguration(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed reading webhook configuration")
+	}
+
+	for _, clientConfig := range m.clientConfigList(webhookConf) {
+		if clientConfig.Service == nil {
+			continue
+		}
+		port := int32(defaultServicePort)
+		if clientConfig.Service.Port != nil {
+			port = *clientConfig.Service.Port
+		}
+		serviceKey := types.NamespacedName{Namespace: clientConfig.Service.Namespace, Name: clientConfig.Service.Name}
+		err = m.applyService(ctx, serviceKey, port)
+		if err != nil {
+			return errors.Wrapf(err, "failed applying service %s", serviceKey)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) applyService(ctx context.Context, serviceKey types.NamespacedName, port int32) error {
+	targetPort := m.service.TargetPort
+	if targetPort == 0 {
+		targetPort = port
+	}
+	ports := []corev1.ServicePort{{
+		Name:       "webhook",
+		Protocol:   corev1.ProtocolTCP,
+		Port:       port,
+		TargetPort: intstr.FromInt(int(targetPort)),
+	}}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		service := &corev1.Service{}
+		err := m.get(ctx, serviceKey, service)
+		if apierrors.IsNotFound(err) {
+			m.log.Info("Creating service", "service", serviceKey)
+			service = &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: serviceKey.Namespace, Name: serviceKey.Name},
+				Spec: corev1.ServiceSpec{
+					Selector: m.service.Selector,
+					Ports:    ports,
+				},
+			}
+			m.setExtraMetadata(service)
+			return m.client.Create(ctx, service)
+		} else if err != nil {
+			return err
+		}
+
+		if reflect.DeepEqual(service.Spec.Selector, m.service.Selector) && servicePortsEqual(service.Spec.Ports, ports) {
+			return nil
+		}
+		m.log.Info("Updating service", "service", serviceKey)
+		service.Spec.Selector = m.service.Selector
+		service.Spec.Ports = ports
+		return m.client.Update(ctx, service)
+	})
+}
+
+// servicePortsEqual compares the fields of the ports set by applyService,
+// the rest are defaulted by the apiserver.
+func servicePortsEqual(ports, expectedPorts []corev1.ServicePort) bool {
+	if len(ports) != len(expectedPorts) {
+		return false
+	}
+	for i := range ports {
+		if ports[i].Name != expectedPorts[i].Name || ports[i].Protocol != expectedPorts[i].Protocol ||
+			ports[i].Port != expectedPorts[i].Port || ports[i].TargetPort != expectedPorts[i].TargetPort {
+			return false
+		}
+	}
+	return true
+}
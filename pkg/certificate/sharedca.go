@@ -0,0 +1,99 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate/triple"
+)
+
+// issueCA returns the CA to rotate to, a new one or with
+// Options.SharedCASecret the one shared with the other Managers.
+func (m *Manager) issueCA(ctx context.Context) (*triple.KeyPair, error) {
+	if m.sharedCASecret == nil {
+		return m.issuer.IssueCA(m.webhookName, m.caCertDuration)
+	}
+	return m.sharedCA(ctx)
+}
+
+// sharedCA returns the CA at Options.SharedCASecret if it's present and not
+// due for rotation, so the Managers from other processes converge to it.
+// Otherwise a new CA is issued and stored with optimistic concurrency, only
+// if this Manager holds the rotation Lease, so concurrent Managers never
+// fight over the secret, they retry until the Lease holder stores it.
+func (m *Manager) sharedCA(ctx context.Context) (*triple.KeyPair, error) {
+	caSecret := &corev1.Secret{}
+	err := m.get(ctx, *m.sharedCASecret, caSecret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, errors.Wrapf(err, "failed reading shared CA secret %s", *m.sharedCASecret)
+	}
+	caSecretFound := err == nil
+
+	if caSecretFound {
+		currentCA, currentCAErr := m.getCAKeyPair(ctx)
+		if currentCAErr == nil && m.now().Before(m.nextRotationDeadlineForCert(currentCA.Cert, m.caOverlapDuration)) {
+			m.log.Info("Using shared CA", "secret", *m.sharedCASecret)
+			return currentCA, nil
+		}
+	}
+
+	acquired, err := m.acquireCARotationLease(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, errors.Errorf("shared CA secret %s has to be rotated and the Lease %s is held by other Manager, "+
+			"waiting for it", *m.sharedCASecret, m.caRotationLeaseKey())
+	}
+
+	m.log.Info("Rotating shared CA", "secret", *m.sharedCASecret)
+	caKeyPair, err := m.issuer.IssueCA(m.sharedCASecret.Name, m.caCertDuration)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed generating shared CA cert/key")
+	}
+
+	if !caSecretFound {
+		caSecret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: m.sharedCASecret.Namespace, Name: m.sharedCASecret.Name},
+			Type:       corev1.SecretTypeOpaque,
+		}
+	}
+	caSecret, err = m.withPrivateKeyEncoding(populateCASecret)(caSecret, caKeyPair)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed populating shared CA secret")
+	}
+	m.setExtraMetadata(caSecret)
+
+	// The secret resourceVersion makes the update fail if other Manager
+	// has changed it meanwhile
+	if caSecretFound {
+		err = m.storage.Update(ctx, caSecret)
+	} else {
+		err = m.storage.Create(ctx, caSecret)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed storing shared CA secret %s", *m.sharedCASecret)
+	}
+	return caKeyPair, nil
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Storage reads and writes the objects that contains the certificate chain
+// data, the CA and service secrets and the CA ConfigMap. The default
+// implementation stores them at the cluster using the controller-runtime
+// client, other implementations can keep them at files or external secret
+// managers while re-using the same rotation and cleanup logic. The rest of
+// objects, like the webhook configuration, Services or Leases, are always
+// read and written with the Manager client.
+//
+// Get has to return an error satisfying apierrors.IsNotFound if the object
+// is not present, so the Manager knows it has to create it.
+type Storage interface {
+	// Get reads the object identified by key into obj
+	Get(ctx context.Context, key types.NamespacedName, obj client.Object) error
+
+	// Create stores a new object
+	Create(ctx context.Context, obj client.Object) error
+
+	// Update stores an already present object, it has to return an error
+	// satisfying apierrors.IsConflict if obj is stale
+	Update(ctx context.Context, obj client.Object) error
+}
+
+// newStorage returns Options.Storage, or the default clientStorage
+func newStorage(cli client.Client, options *Options) Storage {
+	if options.Storage != nil {
+		return options.Storage
+	}
+	return &clientStorage{client: cli}
+}
+
+// get reads an object, from the storage for secrets and configmaps and with
+// the client for the rest, logging it at V(2) so object reads are only
+// visible at the most verbose level.
+func (m *Manager) get(ctx context.Context, key types.NamespacedName, obj client.Object) error {
+	m.log.V(2).Info("Reading object", "kind", fmt.Sprintf("%T", obj), "key", key)
+	switch obj.(type) {
+	case *corev1.Secret, *corev1.ConfigMap:
+		return m.storage.Get(ctx, key, obj)
+	}
+	return getWhenCacheStarted(ctx, m.client, key, obj)
+}
+
+// getUnlabeled reads an object that may not be labeled with
+// ManagedLabelKey yet, like the secrets provided by the user or the ones
+// created by other tools, with the API reader so NewCache does not hide
+// them. Custom storages are not cached by the Manager so they are read
+// with get.
+func (m *Manager) getUnlabeled(ctx context.Context, key types.NamespacedName, obj client.Object) error {
+	if _, isClientStorage := m.storage.(*clientStorage); m.apiReader == nil || !isClientStorage {
+		return m.get(ctx, key, obj)
+	}
+	m.log.V(2).Info("Reading object with API reader", "kind", fmt.Sprintf("%T", obj), "key", key)
+	return m.apiReader.Get(ctx, key, obj)
+}
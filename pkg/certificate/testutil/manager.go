@@ -0,0 +1,40 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testutil
+
+import (
+	"github.com/pkg/errors"
+
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate"
+)
+
+// NewManager returns a certificate Manager backed by a controller-runtime
+// fake client populated with objects, so Reconcile can be exercised at unit
+// tests without envtest binaries. The fake client is returned to inspect
+// the secrets and webhook configuration written by the Manager.
+func NewManager(options *certificate.Options, objects ...client.Object) (*certificate.Manager, client.Client, error) {
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(objects...).Build()
+	manager, err := certificate.NewManager(fakeClient, options)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed creating certificate manager with fake client")
+	}
+	return manager, fakeClient, nil
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package testutil helps testing webhook deployments using the certificate
+// Manager, it generates certificate chains at any point of their validity
+// period, builds the secrets and webhook configurations the Manager expects
+// and verifies the chain invariants.
+package testutil
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate"
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate/triple"
+)
+
+// Chain is a CA and a service key pair signed by it
+type Chain struct {
+	CA      *triple.KeyPair
+	Service *triple.KeyPair
+}
+
+// NewChain generates a chain for service issued at issuedAt, the CA
+// expires after caDuration and the service certificate after certDuration.
+func NewChain(service types.NamespacedName, issuedAt time.Time, caDuration, certDuration time.Duration) (*Chain, error) {
+	now := func() time.Time { return issuedAt }
+	ca, err := triple.NewCAWithClock(service.Name+"-ca", caDuration, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed generating CA")
+	}
+	serviceKeyPair, err := triple.NewServerKeyPairWithClock(ca, service.Name+"."+service.Namespace+".pod.cluster.local",
+		service.Name, service.Namespace, "cluster.local", nil, nil, certDuration, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed generating service key pair")
+	}
+	return &Chain{CA: ca, Service: serviceKeyPair}, nil
+}
+
+// NewExpiredChain generates a chain for service whose CA and service
+// certificates expired expiredFor ago
+func NewExpiredChain(service types.NamespacedName, duration, expiredFor time.Duration) (*Chain, error) {
+	return NewChain(service, time.Now().Add(-duration-expiredFor), duration, duration)
+}
+
+// NewNearExpiryChain generates a chain for service whose CA and service
+// certificates expire after remaining
+func NewNearExpiryChain(service types.NamespacedName, duration, remaining time.Duration) (*Chain, error) {
+	return NewChain(service, time.Now().Add(remaining-duration), duration, duration)
+}
+
+// CABundle returns the PEM CA certificate of the chain
+func (c *Chain) CABundle() []byte {
+	return triple.EncodeCertPEM(c.CA.Cert)
+}
+
+// CASecret returns the CA secret the Manager uses for webhookName
+func (c *Chain) CASecret(namespace, webhookName string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: managedObjectMeta(namespace, webhookName+"-ca"),
+		Type:       corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			certificate.CACertKey:       triple.EncodeCertPEM(c.CA.Cert),
+			certificate.CAPrivateKeyKey: triple.EncodePrivateKeyPEM(c.CA.Key),
+		},
+	}
+}
+
+// TLSSecret returns the TLS secret the Manager uses for service
+func (c *Chain) TLSSecret(service types.NamespacedName) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: managedObjectMeta(service.Namespace, service.Name),
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       triple.EncodeCertPEM(c.Service.Cert),
+			corev1.TLSPrivateKeyKey: triple.EncodePrivateKeyPEM(c.Service.Key),
+			certificate.CACertKey:   c.CABundle(),
+		},
+	}
+}
+
+// MutatingWebhookConfiguration returns a webhook configuration named name
+// with one webhook pointing to service and trusting caBundle
+func MutatingWebhookConfiguration(name string, service types.NamespacedName,
+	caBundle []byte) *admissionregistrationv1.MutatingWebhookConfiguration {
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	return &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name:                    name + ".example.com",
+			ClientConfig:            clientConfig(service, caBundle),
+			SideEffects:             &sideEffects,
+			AdmissionReviewVersions: []string{"v1"},
+		}},
+	}
+}
+
+// ValidatingWebhookConfiguration returns a webhook configuration named name
+// with one webhook pointing to service and trusting caBundle
+func ValidatingWebhookConfiguration(name string, service types.NamespacedName,
+	caBundle []byte) *admissionregistrationv1.ValidatingWebhookConfiguration {
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	return &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name:                    name + ".example.com",
+			ClientConfig:            clientConfig(service, caBundle),
+			SideEffects:             &sideEffects,
+			AdmissionReviewVersions: []string{"v1"},
+		}},
+	}
+}
+
+// VerifyChain checks the invariants the Manager keeps at the chain: the
+// CA secret certificate is at caBundle and the TLS secret certificate is
+// signed by it, valid at now, and matches its private key.
+func VerifyChain(caBundle []byte, caSecret, tlsSecret *corev1.Secret, now time.Time) error {
+	caCerts, err := triple.ParseCertsPEM(caSecret.Data[certificate.CACertKey])
+	if err != nil {
+		return errors.Wrap(err, "failed parsing CA secret certificate")
+	}
+	bundleCerts, err := triple.ParseCertsPEM(caBundle)
+	if err != nil {
+		return errors.Wrap(err, "failed parsing CABundle")
+	}
+	if !containsCert(bundleCerts, caCerts[0]) {
+		return errors.New("CA secret certificate is not at CABundle")
+	}
+
+	err = triple.VerifyTLSAt(tlsSecret.Data[corev1.TLSCertKey], tlsSecret.Data[corev1.TLSPrivateKeyKey],
+		triple.EncodeCertPEM(caCerts[0]), now)
+	if err != nil {
+		return errors.Wrap(err, "TLS secret is not valid for CA secret")
+	}
+	return nil
+}
+
+func containsCert(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+func clientConfig(service types.NamespacedName, caBundle []byte) admissionregistrationv1.WebhookClientConfig {
+	path := fmt.Sprintf("/%s", service.Name)
+	return admissionregistrationv1.WebhookClientConfig{
+		Service: &admissionregistrationv1.ServiceReference{
+			Name:      service.Name,
+			Namespace: service.Namespace,
+			Path:      &path,
+		},
+		CABundle: caBundle,
+	}
+}
+
+// managedObjectMeta marks the object as managed by the Manager, the managed
+// annotation has the same key as the label
+func managedObjectMeta(namespace, name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        name,
+		Namespace:   namespace,
+		Labels:      map[string]string{certificate.ManagedLabelKey: ""},
+		Annotations: map[string]string{certificate.ManagedLabelKey: ""},
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testutil
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate"
+)
+
+var _ = Describe("testutil", func() {
+	service := types.NamespacedName{Namespace: "foo-namespace", Name: "foo-service"}
+
+	It("should generate a chain passing VerifyChain", func() {
+		chain, err := NewNearExpiryChain(service, time.Hour, time.Minute)
+		Expect(err).To(Succeed(), "should success generating chain")
+		Expect(chain.Service.Cert.NotAfter).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+		Expect(VerifyChain(chain.CABundle(), chain.CASecret(service.Namespace, "foo-webhook"),
+			chain.TLSSecret(service), time.Now())).To(Succeed())
+	})
+
+	It("should fail VerifyChain for an expired chain", func() {
+		chain, err := NewExpiredChain(service, time.Hour, time.Minute)
+		Expect(err).To(Succeed(), "should success generating chain")
+		Expect(VerifyChain(chain.CABundle(), chain.CASecret(service.Namespace, "foo-webhook"),
+			chain.TLSSecret(service), time.Now())).ToNot(Succeed())
+	})
+
+	It("should fail VerifyChain if the CA is not at the CABundle", func() {
+		chain, err := NewChain(service, time.Now(), time.Hour, time.Hour)
+		Expect(err).To(Succeed(), "should success generating chain")
+		otherChain, err := NewChain(service, time.Now(), time.Hour, time.Hour)
+		Expect(err).To(Succeed(), "should success generating other chain")
+		Expect(VerifyChain(otherChain.CABundle(), chain.CASecret(service.Namespace, "foo-webhook"),
+			chain.TLSSecret(service), time.Now())).ToNot(Succeed())
+	})
+
+	It("should reconcile a Manager backed by a fake client", func() {
+		webhookName := "foo-webhook"
+		// With the default overlap the rotation deadline is the
+		// certificates NotBefore so no rotation would be scheduled ahead
+		manager, fakeClient, err := NewManager(&certificate.Options{
+			WebhookName:         webhookName,
+			WebhookType:         certificate.MutatingWebhook,
+			Namespace:           service.Namespace,
+			CARotateInterval:    2 * time.Hour,
+			CAOverlapInterval:   time.Hour,
+			CertRotateInterval:  time.Hour,
+			CertOverlapInterval: 30 * time.Minute,
+		}, MutatingWebhookConfiguration(webhookName, service, nil))
+		Expect(err).To(Succeed(), "should success creating manager with fake client")
+
+		result, err := manager.Reconcile(context.TODO(), reconcile.Request{})
+		Expect(err).To(Succeed(), "should success reconciling")
+		Expect(result.RequeueAfter).To(BeNumerically(">", 0), "should schedule next rotation")
+		Expect(manager.NextSchedule().ServiceRotation).To(BeTemporally("~", time.Now().Add(30*time.Minute), time.Minute),
+			"should schedule the services rotation before their overlap")
+
+		webhook := admissionregistrationv1.MutatingWebhookConfiguration{}
+		Expect(fakeClient.Get(context.TODO(), types.NamespacedName{Name: webhookName}, &webhook)).To(Succeed())
+		caSecret := corev1.Secret{}
+		Expect(fakeClient.Get(context.TODO(), types.NamespacedName{Namespace: service.Namespace, Name: webhookName + "-ca"},
+			&caSecret)).To(Succeed())
+		tlsSecret := corev1.Secret{}
+		Expect(fakeClient.Get(context.TODO(), service, &tlsSecret)).To(Succeed())
+
+		Expect(VerifyChain(webhook.Webhooks[0].ClientConfig.CABundle, &caSecret, &tlsSecret, time.Now())).To(Succeed())
+	})
+})
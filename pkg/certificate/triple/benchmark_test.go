@@ -0,0 +1,70 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package triple
+
+import (
+	"crypto/x509"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func BenchmarkNewCA(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_, err := NewCA("benchmark", time.Hour)
+		if err != nil {
+			b.Fatalf("failed creating CA: %v", err)
+		}
+	}
+}
+
+func BenchmarkNewServerKeyPair(b *testing.B) {
+	ca, err := NewCA("benchmark", time.Hour)
+	if err != nil {
+		b.Fatalf("failed creating CA: %v", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err = NewServerKeyPair(ca, "benchmark", "service", "namespace", "cluster.local", nil, nil, time.Hour)
+		if err != nil {
+			b.Fatalf("failed creating server key pair: %v", err)
+		}
+	}
+}
+
+// BenchmarkCertsPEM measures the encoding and parsing of CABundles and
+// overlapped tls.crt with 1, 10 and 100 certificates
+func BenchmarkCertsPEM(b *testing.B) {
+	ca, err := NewCA("benchmark", time.Hour)
+	if err != nil {
+		b.Fatalf("failed creating CA: %v", err)
+	}
+	for _, certsCount := range []int{1, 10, 100} {
+		certs := make([]*x509.Certificate, certsCount)
+		for i := range certs {
+			certs[i] = ca.Cert
+		}
+		b.Run(fmt.Sprintf("certs=%d", certsCount), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err = ParseCertsPEM(EncodeCertsPEM(certs))
+				if err != nil {
+					b.Fatalf("failed parsing certificates PEM: %v", err)
+				}
+			}
+		})
+	}
+}
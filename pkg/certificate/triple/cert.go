@@ -17,6 +17,7 @@
 package triple
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -26,7 +27,6 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
-	"math"
 	"math/big"
 	"net"
 	"time"
@@ -37,7 +37,8 @@ import (
 )
 
 const (
-	rsaKeySize = 2048
+	rsaKeySize       = 2048
+	serialNumberBits = 128
 )
 
 var (
@@ -50,6 +51,17 @@ type Config struct {
 	Organization []string
 	AltNames     AltNames
 	Usages       []x509.ExtKeyUsage
+
+	// Now returns the current time used for the certificate validity, if
+	// not set the package Now is used
+	Now func() time.Time
+}
+
+func (cfg *Config) now() time.Time {
+	if cfg.Now != nil {
+		return cfg.Now()
+	}
+	return Now()
 }
 
 // AltNames contains the domain names and IP addresses that will be added
@@ -65,11 +77,27 @@ func NewPrivateKey() (*rsa.PrivateKey, error) {
 	return rsa.GenerateKey(rand.Reader, rsaKeySize)
 }
 
+// NewSerialNumber returns a random positive serial number of up to 128
+// bits, following RFC 5280 and the CA/Browser Forum guidance of at least
+// 64 bits of entropy
+func NewSerialNumber() (*big.Int, error) {
+	serialNumberLimit := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), serialNumberBits), big.NewInt(1))
+	serial, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, err
+	}
+	return serial.Add(serial, big.NewInt(1)), nil
+}
+
 // NewSelfSignedCACert creates a CA certificate
 func NewSelfSignedCACert(cfg *Config, key crypto.Signer, duration time.Duration) (*x509.Certificate, error) {
-	now := Now()
+	serial, err := NewSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := cfg.now()
 	tmpl := x509.Certificate{
-		SerialNumber: new(big.Int).SetInt64(0),
+		SerialNumber: serial,
 		Subject: pkix.Name{
 			CommonName:   cfg.CommonName,
 			Organization: cfg.Organization,
@@ -90,12 +118,12 @@ func NewSelfSignedCACert(cfg *Config, key crypto.Signer, duration time.Duration)
 // NewSignedCert creates a signed certificate using the given CA certificate and key
 func NewSignedCert(cfg *Config, key crypto.Signer, caCert *x509.Certificate,
 	caKey crypto.Signer, duration time.Duration) (*x509.Certificate, error) {
-	serial, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	serial, err := NewSerialNumber()
 	if err != nil {
 		return nil, err
 	}
-	if cfg.CommonName == "" {
-		return nil, errors.New("must specify a CommonName")
+	if cfg.CommonName == "" && len(cfg.AltNames.DNSNames) == 0 && len(cfg.AltNames.IPs) == 0 {
+		return nil, errors.New("must specify a CommonName or at least one SAN")
 	}
 	if len(cfg.Usages) == 0 {
 		return nil, errors.New("must specify at least one ExtKeyUsage")
@@ -106,13 +134,14 @@ func NewSignedCert(cfg *Config, key crypto.Signer, caCert *x509.Certificate,
 			CommonName:   cfg.CommonName,
 			Organization: cfg.Organization,
 		},
-		DNSNames:     cfg.AltNames.DNSNames,
-		IPAddresses:  cfg.AltNames.IPs,
-		SerialNumber: serial,
-		NotBefore:    caCert.NotBefore,
-		NotAfter:     Now().Add(duration).UTC(),
-		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:  cfg.Usages,
+		DNSNames:       cfg.AltNames.DNSNames,
+		IPAddresses:    cfg.AltNames.IPs,
+		SerialNumber:   serial,
+		AuthorityKeyId: caCert.SubjectKeyId,
+		NotBefore:      caCert.NotBefore,
+		NotAfter:       cfg.now().Add(duration).UTC(),
+		KeyUsage:       x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    cfg.Usages,
 	}
 
 	certDERBytes, err := x509.CreateCertificate(rand.Reader, &certTmpl, caCert, key.Public(), caKey)
@@ -122,6 +151,22 @@ func NewSignedCert(cfg *Config, key crypto.Signer, caCert *x509.Certificate,
 	return x509.ParseCertificate(certDERBytes)
 }
 
+// FindIssuer returns the CA from cas that issued cert, matching the cert
+// AuthorityKeyId with the CAs SubjectKeyId so the order of cas does not
+// matter, certificates without AuthorityKeyId are matched by signature.
+// It returns nil if none of them issued it
+func FindIssuer(cert *x509.Certificate, cas []*x509.Certificate) *x509.Certificate {
+	for _, ca := range cas {
+		if len(cert.AuthorityKeyId) > 0 && !bytes.Equal(cert.AuthorityKeyId, ca.SubjectKeyId) {
+			continue
+		}
+		if cert.CheckSignatureFrom(ca) == nil {
+			return ca
+		}
+	}
+	return nil
+}
+
 // MakeEllipticPrivateKeyPEM creates an ECDSA private key
 func MakeEllipticPrivateKeyPEM() ([]byte, error) {
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -142,6 +187,12 @@ func MakeEllipticPrivateKeyPEM() ([]byte, error) {
 }
 
 func VerifyTLS(certsPEM, keyPEM, caBundle []byte) error {
+	return VerifyTLSAt(certsPEM, keyPEM, caBundle, Now())
+}
+
+// VerifyTLSAt is VerifyTLS checking the certificates validity at now
+// instead of the package Now
+func VerifyTLSAt(certsPEM, keyPEM, caBundle []byte, now time.Time) error {
 	logger := logf.Log.WithName("kube-admission-webhook.VerifyTLS")
 
 	_, err := ParsePrivateKeyPEM(keyPEM)
@@ -163,7 +214,7 @@ func VerifyTLS(certsPEM, keyPEM, caBundle []byte) error {
 	opts := x509.VerifyOptions{
 		Roots:       cas,
 		DNSName:     certs[0].DNSNames[0],
-		CurrentTime: Now(),
+		CurrentTime: now,
 	}
 
 	if _, err = certs[0].Verify(opts); err != nil {
@@ -175,6 +226,6 @@ func VerifyTLS(certsPEM, keyPEM, caBundle []byte) error {
 		return errors.Wrap(err, "failed parsing TLS public/private key")
 	}
 
-	logger.Info("TLS certificates chain verified")
+	logger.V(2).Info("TLS certificates chain verified")
 	return nil
 }
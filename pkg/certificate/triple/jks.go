@@ -0,0 +1,95 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package triple
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // JKS integrity check is defined with SHA-1
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	jksMagic            = 0xFEEDFEED
+	jksVersion          = 2
+	jksTrustedCertTag   = 2
+	jksCertType         = "X.509"
+	jksIntegritySalt    = "Mighty Aphrodite"
+	jksTrustedCertAlias = "ca-%d"
+)
+
+// EncodeTrustStoreJKS returns a Java KeyStore containing the certs as
+// trusted certificate entries named ca-0, ca-1... following the certs
+// order, the store integrity is protected with password.
+func EncodeTrustStoreJKS(certs []*x509.Certificate, password string, creationDate time.Time) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	writeUint32(buf, jksMagic)
+	writeUint32(buf, jksVersion)
+	writeUint32(buf, uint32(len(certs)))
+
+	timestamp := uint64(creationDate.UnixNano() / int64(time.Millisecond))
+	for i, cert := range certs {
+		writeUint32(buf, jksTrustedCertTag)
+		if err := writeJKSString(buf, fmt.Sprintf(jksTrustedCertAlias, i)); err != nil {
+			return nil, err
+		}
+		writeUint64(buf, timestamp)
+		if err := writeJKSString(buf, jksCertType); err != nil {
+			return nil, err
+		}
+		writeUint32(buf, uint32(len(cert.Raw)))
+		buf.Write(cert.Raw)
+	}
+
+	digest := sha1.New() //nolint:gosec
+	for _, c := range utf16.Encode([]rune(password)) {
+		digest.Write([]byte{byte(c >> 8), byte(c)})
+	}
+	digest.Write([]byte(jksIntegritySalt))
+	digest.Write(buf.Bytes())
+	buf.Write(digest.Sum(nil))
+
+	return buf.Bytes(), nil
+}
+
+func writeUint32(buf *bytes.Buffer, value uint32) {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, value)
+	buf.Write(b)
+}
+
+func writeUint64(buf *bytes.Buffer, value uint64) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, value)
+	buf.Write(b)
+}
+
+// writeJKSString writes s the way java.io.DataOutput.writeUTF does, the
+// values written here are ASCII so modified UTF-8 is the same as UTF-8.
+func writeJKSString(buf *bytes.Buffer, s string) error {
+	if len(s) > 0xFFFF {
+		return fmt.Errorf("string too long for JKS: %d bytes", len(s))
+	}
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(len(s)))
+	buf.Write(b)
+	buf.WriteString(s)
+	return nil
+}
@@ -21,7 +21,6 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
-	"errors"
 	"fmt"
 )
 
@@ -42,6 +41,38 @@ const (
 	CertsListSizeLimit = 100
 )
 
+// PEMError describes why PEM encoded certificates or keys could not be
+// parsed, to find out which block of a corrupted secret is wrong
+type PEMError struct {
+	// Block is the index of the failing PEM block, -1 if the data has no
+	// PEM blocks
+	Block int
+
+	// Type is the failing PEM block type
+	Type string
+
+	// Reason describes the failure
+	Reason string
+
+	// Err is the underlying parsing error if any
+	Err error
+}
+
+func (e *PEMError) Error() string {
+	message := e.Reason
+	if e.Block >= 0 {
+		message = fmt.Sprintf("PEM block %d (%s): %s", e.Block, e.Type, e.Reason)
+	}
+	if e.Err != nil {
+		message = fmt.Sprintf("%s: %v", message, e.Err)
+	}
+	return message
+}
+
+func (e *PEMError) Unwrap() error {
+	return e.Err
+}
+
 // EncodePublicKeyPEM returns PEM-encoded public data
 func EncodePublicKeyPEM(key *rsa.PublicKey) ([]byte, error) {
 	der, err := x509.MarshalPKIXPublicKey(key)
@@ -64,6 +95,20 @@ func EncodePrivateKeyPEM(key *rsa.PrivateKey) []byte {
 	return pem.EncodeToMemory(&block)
 }
 
+// EncodePKCS8PrivateKeyPEM returns PEM-encoded PKCS#8 "PRIVATE KEY" data,
+// required by some non Go TLS stacks and HSM import tools
+func EncodePKCS8PrivateKeyPEM(key interface{}) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	block := pem.Block{
+		Type:  PrivateKeyBlockType,
+		Bytes: der,
+	}
+	return pem.EncodeToMemory(&block), nil
+}
+
 // EncodeCertPEM returns PEM-endcoded certificate data
 func EncodeCertPEM(cert *x509.Certificate) []byte {
 	block := pem.Block{
@@ -87,36 +132,44 @@ func EncodeCertsPEM(certs []*x509.Certificate) []byte {
 // Recognizes PEM blocks for "EC PRIVATE KEY", "RSA PRIVATE KEY", or "PRIVATE KEY"
 func ParsePrivateKeyPEM(keyData []byte) (interface{}, error) {
 	var privateKeyPemBlock *pem.Block
-	for {
+	var pemErr *PEMError
+	for index := 0; ; index++ {
 		privateKeyPemBlock, keyData = pem.Decode(keyData)
 		if privateKeyPemBlock == nil {
+			if index == 0 {
+				return nil, &PEMError{Block: -1, Reason: "data does not contain any PEM block"}
+			}
 			break
 		}
 
+		var key interface{}
+		var err error
 		switch privateKeyPemBlock.Type {
 		case ECPrivateKeyBlockType:
 			// ECDSA Private Key in ASN.1 format
-			if key, err := x509.ParseECPrivateKey(privateKeyPemBlock.Bytes); err == nil {
-				return key, nil
-			}
+			key, err = x509.ParseECPrivateKey(privateKeyPemBlock.Bytes)
 		case RSAPrivateKeyBlockType:
 			// RSA Private Key in PKCS#1 format
-			if key, err := x509.ParsePKCS1PrivateKey(privateKeyPemBlock.Bytes); err == nil {
-				return key, nil
-			}
+			key, err = x509.ParsePKCS1PrivateKey(privateKeyPemBlock.Bytes)
 		case PrivateKeyBlockType:
 			// RSA or ECDSA Private Key in unencrypted PKCS#8 format
-			if key, err := x509.ParsePKCS8PrivateKey(privateKeyPemBlock.Bytes); err == nil {
-				return key, nil
+			key, err = x509.ParsePKCS8PrivateKey(privateKeyPemBlock.Bytes)
+		default:
+			// tolerate non-key PEM blocks for compatibility with things like "EC PARAMETERS" blocks
+			// originally, only the first PEM block was parsed and expected to be a key block
+			if pemErr == nil {
+				pemErr = &PEMError{Block: index, Type: privateKeyPemBlock.Type, Reason: "not a private key block"}
 			}
+			continue
 		}
-
-		// tolerate non-key PEM blocks for compatibility with things like "EC PARAMETERS" blocks
-		// originally, only the first PEM block was parsed and expected to be a key block
+		if err == nil {
+			return key, nil
+		}
+		pemErr = &PEMError{Block: index, Type: privateKeyPemBlock.Type, Reason: "invalid private key", Err: err}
 	}
 
 	// we read all the PEM blocks and didn't recognize one
-	return nil, fmt.Errorf("data does not contain a valid RSA or ECDSA private key")
+	return nil, pemErr
 }
 
 // ParsePublicKeysPEM is a helper function for reading an array of rsa.PublicKey or ecdsa.PublicKey from a PEM-encoded byte array.
@@ -162,9 +215,9 @@ func ParsePublicKeysPEM(keyData []byte) ([]interface{}, error) {
 // ParseCertsPEM returns the x509.Certificates contained in the given PEM-encoded byte array
 // Returns an error if a certificate could not be parsed, or if the data does not contain any certificates
 func ParseCertsPEM(pemCerts []byte) ([]*x509.Certificate, error) {
-	ok := false
 	certs := []*x509.Certificate{}
-	for len(pemCerts) > 0 {
+	skipped := &PEMError{Block: -1, Reason: "data does not contain any PEM block"}
+	for index := 0; len(pemCerts) > 0; index++ {
 		var block *pem.Block
 		block, pemCerts = pem.Decode(pemCerts)
 		if block == nil {
@@ -172,20 +225,22 @@ func ParseCertsPEM(pemCerts []byte) ([]*x509.Certificate, error) {
 		}
 		// Only use PEM "CERTIFICATE" blocks without extra headers
 		if block.Type != CertificateBlockType || len(block.Headers) != 0 {
+			if skipped.Block < 0 {
+				skipped = &PEMError{Block: index, Type: block.Type, Reason: "not a certificate block without headers"}
+			}
 			continue
 		}
 
 		cert, err := x509.ParseCertificate(block.Bytes)
 		if err != nil {
-			return certs, err
+			return certs, &PEMError{Block: index, Type: block.Type, Reason: "invalid certificate", Err: err}
 		}
 
 		certs = append(certs, cert)
-		ok = true
 	}
 
-	if !ok {
-		return certs, errors.New("data does not contain any valid RSA or ECDSA certificates")
+	if len(certs) == 0 {
+		return certs, skipped
 	}
 	return certs, nil
 }
@@ -32,6 +32,12 @@ type KeyPair struct {
 }
 
 func NewCA(name string, duration time.Duration) (*KeyPair, error) {
+	return NewCAWithClock(name, duration, nil)
+}
+
+// NewCAWithClock is NewCA with the certificate validity starting at now()
+// instead of the package Now, if now is nil the package Now is used
+func NewCAWithClock(name string, duration time.Duration, now func() time.Time) (*KeyPair, error) {
 	key, err := NewPrivateKey()
 	if err != nil {
 		return nil, fmt.Errorf("unable to create a private key for a new CA: %v", err)
@@ -39,6 +45,7 @@ func NewCA(name string, duration time.Duration) (*KeyPair, error) {
 
 	config := Config{
 		CommonName: name,
+		Now:        now,
 	}
 
 	cert, err := NewSelfSignedCACert(&config, key, duration)
@@ -51,8 +58,19 @@ func NewCA(name string, duration time.Duration) (*KeyPair, error) {
 	}, nil
 }
 
+// NewServerKeyPair issues a certificate for the service DNS names signed by
+// ca, if commonName is empty the certificate subject is empty and clients
+// rely only on the SANs
 func NewServerKeyPair(ca *KeyPair, commonName, svcName, svcNamespace,
 	dnsDomain string, ips, hostnames []string, duration time.Duration) (*KeyPair, error) {
+	return NewServerKeyPairWithClock(ca, commonName, svcName, svcNamespace, dnsDomain, ips, hostnames, duration, nil)
+}
+
+// NewServerKeyPairWithClock is NewServerKeyPair with the certificate
+// expiration calculated from now() instead of the package Now, if now is
+// nil the package Now is used
+func NewServerKeyPairWithClock(ca *KeyPair, commonName, svcName, svcNamespace,
+	dnsDomain string, ips, hostnames []string, duration time.Duration, now func() time.Time) (*KeyPair, error) {
 	key, err := NewPrivateKey()
 	if err != nil {
 		return nil, fmt.Errorf("unable to create a server private key: %v", err)
@@ -80,6 +98,7 @@ func NewServerKeyPair(ca *KeyPair, commonName, svcName, svcNamespace,
 		CommonName: commonName,
 		AltNames:   altNames,
 		Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		Now:        now,
 	}
 	cert, err := NewSignedCert(config, key, ca.Cert, ca.Key, duration)
 	if err != nil {
@@ -17,7 +17,15 @@
 package triple
 
 import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec
 	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -48,7 +56,12 @@ var _ = Describe("Cert library", func() {
 
 			Expect(privateKey).ToNot(BeNil(), "should generate a private key")
 			Expect(caCert).ToNot(BeNil(), "should generate a CA certificate")
-			Expect(caCert.SerialNumber.Int64()).To(Equal(int64(0)), "should have zero as serial number")
+			Expect(caCert.SerialNumber.Sign()).To(Equal(1), "should have a positive serial number")
+			Expect(caCert.SerialNumber.BitLen()).To(BeNumerically("<=", 128), "should have a serial number of up to 128 bits")
+
+			otherKeyAndCert, err := NewCA(name, duration)
+			Expect(err).ToNot(HaveOccurred(), "should succeed generating other CA")
+			Expect(otherKeyAndCert.Cert.SerialNumber).ToNot(Equal(caCert.SerialNumber), "should have a random serial number")
 			Expect(caCert.Subject.CommonName).To(Equal(name), "should take CommonName from name field")
 			Expect(caCert.NotBefore).To(BeTemporally("~", now.UTC(), time.Second), "should set NotBefore to now")
 			Expect(caCert.NotAfter).To(BeTemporally("~", now.Add(duration).UTC(), time.Second), "should  set NotAfter to now + duration")
@@ -61,6 +74,96 @@ var _ = Describe("Cert library", func() {
 
 	})
 
+	Context("when NewServerKeyPair is called without CommonName", func() {
+		It("should generate a certificate with only SANs", func() {
+			ca, err := NewCA("foo-ca", time.Hour)
+			Expect(err).ToNot(HaveOccurred(), "should succeed generating CA")
+
+			keyPair, err := NewServerKeyPair(ca, "", "foo", "bar", "cluster.local", nil, nil, time.Hour)
+			Expect(err).ToNot(HaveOccurred(), "should succeed generating server key pair")
+			Expect(keyPair.Cert.Subject.CommonName).To(BeEmpty(), "should not set CommonName")
+			Expect(keyPair.Cert.DNSNames).To(ContainElement("foo.bar.svc"), "should include service DNS SANs")
+
+			err = VerifyTLS(EncodeCertPEM(keyPair.Cert), EncodePrivateKeyPEM(keyPair.Key), EncodeCertPEM(ca.Cert))
+			Expect(err).ToNot(HaveOccurred(), "should verify the SAN only certificate")
+		})
+	})
+
+	Context("when FindIssuer is called", func() {
+		It("should match the issuer by AKI/SKI independently of the CAs order", func() {
+			ca, err := NewCA("foo-ca", time.Hour)
+			Expect(err).ToNot(HaveOccurred(), "should succeed generating CA")
+			otherCA, err := NewCA("other-ca", time.Hour)
+			Expect(err).ToNot(HaveOccurred(), "should succeed generating other CA")
+
+			keyPair, err := NewServerKeyPair(ca, "foo.bar", "foo", "bar", "cluster.local", nil, nil, time.Hour)
+			Expect(err).ToNot(HaveOccurred(), "should succeed generating server key pair")
+			Expect(keyPair.Cert.AuthorityKeyId).To(Equal(ca.Cert.SubjectKeyId), "should set AKI from CA SKI")
+
+			Expect(FindIssuer(keyPair.Cert, []*x509.Certificate{otherCA.Cert, ca.Cert})).To(Equal(ca.Cert))
+			Expect(FindIssuer(keyPair.Cert, []*x509.Certificate{ca.Cert, otherCA.Cert})).To(Equal(ca.Cert))
+			Expect(FindIssuer(keyPair.Cert, []*x509.Certificate{otherCA.Cert})).To(BeNil())
+		})
+	})
+
+	type parsePEMErrorParams struct {
+		data           []byte
+		expectedBlock  int
+		expectedType   string
+		expectedReason string
+	}
+
+	DescribeTable("when PEM data is invalid",
+		func(c parsePEMErrorParams) {
+			_, err := ParseCertsPEM(c.data)
+			pemErr := &PEMError{}
+			Expect(errors.As(err, &pemErr)).To(BeTrue(), "should return a PEMError")
+			Expect(pemErr.Block).To(Equal(c.expectedBlock), "should point to the failing block")
+			Expect(pemErr.Type).To(Equal(c.expectedType), "should contain the failing block type")
+			Expect(pemErr.Reason).To(Equal(c.expectedReason), "should explain the failure")
+		},
+		Entry("without PEM blocks", parsePEMErrorParams{
+			data:           []byte("This is not PEM"),
+			expectedBlock:  -1,
+			expectedReason: "data does not contain any PEM block",
+		}),
+		Entry("with only a key block", parsePEMErrorParams{
+			data:           pem.EncodeToMemory(&pem.Block{Type: RSAPrivateKeyBlockType, Bytes: []byte("key")}),
+			expectedBlock:  0,
+			expectedType:   RSAPrivateKeyBlockType,
+			expectedReason: "not a certificate block without headers",
+		}),
+		Entry("with a corrupted certificate block", parsePEMErrorParams{
+			data: append(pem.EncodeToMemory(&pem.Block{Type: "EC PARAMETERS", Bytes: []byte("params")}),
+				pem.EncodeToMemory(&pem.Block{Type: CertificateBlockType, Bytes: []byte("corrupted")})...),
+			expectedBlock:  1,
+			expectedType:   CertificateBlockType,
+			expectedReason: "invalid certificate",
+		}),
+	)
+
+	It("should point to the corrupted private key block", func() {
+		_, err := ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: RSAPrivateKeyBlockType, Bytes: []byte("corrupted")}))
+		pemErr := &PEMError{}
+		Expect(errors.As(err, &pemErr)).To(BeTrue(), "should return a PEMError")
+		Expect(pemErr.Block).To(Equal(0))
+		Expect(pemErr.Reason).To(Equal("invalid private key"))
+		Expect(pemErr.Err).To(HaveOccurred(), "should wrap the parsing error")
+	})
+
+	It("should parse PKCS#1 and PKCS#8 encoded private keys", func() {
+		keyPair, err := NewCA("foo-ca", time.Hour)
+		Expect(err).ToNot(HaveOccurred(), "should succeed generating CA")
+		pkcs8KeyPEM, err := EncodePKCS8PrivateKeyPEM(keyPair.Key)
+		Expect(err).ToNot(HaveOccurred(), "should succeed encoding PKCS#8 private key")
+		for _, keyPEM := range [][]byte{EncodePrivateKeyPEM(keyPair.Key), pkcs8KeyPEM} {
+			var key interface{}
+			key, err = ParsePrivateKeyPEM(keyPEM)
+			Expect(err).ToNot(HaveOccurred(), "should succeed parsing private key")
+			Expect(keyPair.Key.Equal(key)).To(BeTrue(), "should parse the original key")
+		}
+	})
+
 	type removeOldestCertsParams struct {
 		certsList         []*x509.Certificate
 		maxListSize       int
@@ -103,3 +206,118 @@ var _ = Describe("Cert library", func() {
 			}),
 	)
 })
+
+var _ = Describe("JKS trust store", func() {
+	It("should encode the certificates as trusted entries with integrity digest", func() {
+		ca1, err := NewCA("ca1", time.Hour)
+		Expect(err).ToNot(HaveOccurred(), "should succeed generating CA")
+		ca2, err := NewCA("ca2", time.Hour)
+		Expect(err).ToNot(HaveOccurred(), "should succeed generating CA")
+
+		password := "changeit"
+		trustStore, err := EncodeTrustStoreJKS([]*x509.Certificate{ca1.Cert, ca2.Cert}, password, time.Now())
+		Expect(err).ToNot(HaveOccurred(), "should succeed encoding JKS trust store")
+
+		Expect(binary.BigEndian.Uint32(trustStore[0:4])).To(Equal(uint32(0xFEEDFEED)), "should start with JKS magic")
+		Expect(binary.BigEndian.Uint32(trustStore[4:8])).To(Equal(uint32(2)), "should be JKS version 2")
+		Expect(binary.BigEndian.Uint32(trustStore[8:12])).To(Equal(uint32(2)), "should contain two entries")
+		Expect(bytes.Contains(trustStore, ca1.Cert.Raw)).To(BeTrue(), "should contain first CA")
+		Expect(bytes.Contains(trustStore, ca2.Cert.Raw)).To(BeTrue(), "should contain second CA")
+
+		content, digest := trustStore[:len(trustStore)-sha1.Size], trustStore[len(trustStore)-sha1.Size:]
+		expectedDigest := sha1.New() //nolint:gosec
+		for _, c := range password {
+			expectedDigest.Write([]byte{0, byte(c)})
+		}
+		expectedDigest.Write([]byte("Mighty Aphrodite"))
+		expectedDigest.Write(content)
+		Expect(digest).To(Equal(expectedDigest.Sum(nil)), "should end with the password integrity digest")
+	})
+
+	It("should decode the entries the way the JKS format defines them", func() {
+		ca, err := NewCA("ca", time.Hour)
+		Expect(err).ToNot(HaveOccurred(), "should succeed generating CA")
+		creationDate := time.Now()
+		trustStore, err := EncodeTrustStoreJKS([]*x509.Certificate{ca.Cert}, "changeit", creationDate)
+		Expect(err).ToNot(HaveOccurred(), "should succeed encoding JKS trust store")
+
+		entries := bytes.NewReader(trustStore[12 : len(trustStore)-sha1.Size])
+		readUint32 := func() uint32 {
+			var value uint32
+			Expect(binary.Read(entries, binary.BigEndian, &value)).To(Succeed())
+			return value
+		}
+		readUTF := func() string {
+			var length uint16
+			Expect(binary.Read(entries, binary.BigEndian, &length)).To(Succeed())
+			value := make([]byte, length)
+			_, err = entries.Read(value)
+			Expect(err).ToNot(HaveOccurred())
+			return string(value)
+		}
+
+		Expect(readUint32()).To(Equal(uint32(2)), "should be a trusted certificate entry")
+		Expect(readUTF()).To(Equal("ca-0"), "should name the entry ca-0")
+		var timestamp uint64
+		Expect(binary.Read(entries, binary.BigEndian, &timestamp)).To(Succeed())
+		Expect(timestamp).To(Equal(uint64(creationDate.UnixNano()/int64(time.Millisecond))), "should use the creation date in millis")
+		Expect(readUTF()).To(Equal("X.509"), "should be a X.509 certificate")
+		certDER := make([]byte, readUint32())
+		_, err = entries.Read(certDER)
+		Expect(err).ToNot(HaveOccurred())
+		cert, err := x509.ParseCertificate(certDER)
+		Expect(err).ToNot(HaveOccurred(), "should contain a DER certificate")
+		Expect(cert.Equal(ca.Cert)).To(BeTrue(), "should contain the CA")
+		Expect(entries.Len()).To(BeZero(), "should not contain anything else before the digest")
+	})
+
+	Context("when keytool is installed", func() {
+		var (
+			keytool, dir, trustStorePath string
+			trustStore                   []byte
+		)
+		BeforeEach(func() {
+			var err error
+			keytool, err = exec.LookPath("keytool")
+			if err != nil {
+				Skip("keytool not found, skipping JKS interoperability test")
+			}
+			ca1, err := NewCA("ca1", time.Hour)
+			Expect(err).ToNot(HaveOccurred(), "should succeed generating CA")
+			ca2, err := NewCA("ca2", time.Hour)
+			Expect(err).ToNot(HaveOccurred(), "should succeed generating CA")
+			trustStore, err = EncodeTrustStoreJKS([]*x509.Certificate{ca1.Cert, ca2.Cert}, "changeit", time.Now())
+			Expect(err).ToNot(HaveOccurred(), "should succeed encoding JKS trust store")
+
+			dir, err = os.MkdirTemp("", "jks")
+			Expect(err).ToNot(HaveOccurred(), "should succeed creating temporary directory")
+			trustStorePath = filepath.Join(dir, "truststore.jks")
+		})
+		AfterEach(func() {
+			if dir != "" {
+				Expect(os.RemoveAll(dir)).To(Succeed(), "should succeed removing temporary directory")
+			}
+		})
+		keytoolList := func(password string) (string, error) {
+			Expect(os.WriteFile(trustStorePath, trustStore, 0o600)).To(Succeed(), "should succeed writing trust store")
+			output, err := exec.Command(keytool, "-list", "-storetype", "JKS", "-keystore", trustStorePath, "-storepass", password).CombinedOutput()
+			return string(output), err
+		}
+		It("should list the trusted certificates", func() {
+			output, err := keytoolList("changeit")
+			Expect(err).ToNot(HaveOccurred(), "keytool should read the trust store: %s", output)
+			Expect(output).To(ContainSubstring("ca-0"), "should list the first CA")
+			Expect(output).To(ContainSubstring("ca-1"), "should list the second CA")
+			Expect(output).To(ContainSubstring("trustedCertEntry"), "should list trusted certificate entries")
+		})
+		It("should fail the integrity check with a wrong password", func() {
+			output, err := keytoolList("wrong")
+			Expect(err).To(HaveOccurred(), "keytool should reject the integrity digest: %s", output)
+		})
+		It("should fail the integrity check if the content is tampered", func() {
+			trustStore[len(trustStore)-sha1.Size-1] ^= 0xFF
+			output, err := keytoolList("changeit")
+			Expect(err).To(HaveOccurred(), "keytool should reject the integrity digest: %s", output)
+		})
+	})
+})
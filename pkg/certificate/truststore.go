@@ -0,0 +1,90 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"crypto/x509"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate/triple"
+)
+
+const (
+	// CATrustStoreKey is the key containing the JKS trust store at the CA
+	// secret or ConfigMap
+	CATrustStoreKey = "truststore.jks"
+
+	// DefaultTrustStorePassword is the password of the JVM default trust store
+	DefaultTrustStorePassword = "changeit"
+)
+
+// applyCATrustStore renders the CA certificates from the CABundle as a JKS
+// trust store at the CA secret, or at the CA ConfigMap if the CA
+// certificate is stored there, it has to be called after every CABundle
+// change so the trust store does not diverge from it.
+func (m *Manager) applyCATrustStore(ctx context.Context) error {
+	if !m.caTrustStore {
+		return nil
+	}
+
+	cas, err := m.getCACertsFromCABundle(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed getting CA certificates from CA bundle")
+	}
+
+	trustStore, err := triple.EncodeTrustStoreJKS(cas, m.caTrustStorePassword, trustStoreCreationDate(cas))
+	if err != nil {
+		return errors.Wrap(err, "failed encoding JKS trust store")
+	}
+
+	if m.caCertInConfigMap {
+		return m.applyConfigMap(ctx, m.caConfigMapKey(), func(configMap *corev1.ConfigMap) {
+			if configMap.BinaryData == nil {
+				configMap.BinaryData = map[string][]byte{}
+			}
+			configMap.BinaryData[CATrustStoreKey] = trustStore
+		})
+	}
+
+	return m.applySecret(ctx, m.caSecretKey(), corev1.SecretTypeOpaque, nil,
+		func(secret *corev1.Secret, _ *triple.KeyPair) (*corev1.Secret, error) {
+			setAnnotation(secret)
+			if secret.Data == nil {
+				secret.Data = map[string][]byte{}
+			}
+			secret.Data[CATrustStoreKey] = trustStore
+			return secret, nil
+		})
+}
+
+// trustStoreCreationDate is the newest NotBefore of the CA certificates so
+// the trust store bytes only change with the CABundle, otherwise every
+// write would update the secret and restart the workloads mounting it.
+func trustStoreCreationDate(cas []*x509.Certificate) time.Time {
+	creationDate := time.Time{}
+	for _, ca := range cas {
+		if ca.NotBefore.After(creationDate) {
+			creationDate = ca.NotBefore
+		}
+	}
+	return creationDate
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// webhookConfigurationWaitInterval is how often the webhook configuration
+// is read while waiting for it
+const webhookConfigurationWaitInterval = 5 * time.Second
+
+// withWebhookConfigurationWait starts runnable once the webhook
+// configuration exists if Options.WaitForWebhookConfiguration is set.
+func (m *Manager) withWebhookConfigurationWait(runnable manager.Runnable) manager.Runnable {
+	if !m.waitForWebhookConfiguration {
+		return runnable
+	}
+	return manager.RunnableFunc(func(ctx context.Context) error {
+		err := m.waitWebhookConfiguration(ctx)
+		if err != nil {
+			return err
+		}
+		return runnable.Start(ctx)
+	})
+}
+
+// waitWebhookConfiguration polls the webhook configuration until it exists
+// or ctx is done.
+func (m *Manager) waitWebhookConfiguration(ctx context.Context) error {
+	webhookConf, err := m.emptyWebhookConfiguration()
+	if err != nil {
+		return err
+	}
+
+	m.log.Info("Waiting for webhook configuration")
+	err = wait.PollImmediateInfiniteWithContext(ctx, webhookConfigurationWaitInterval, func(ctx context.Context) (bool, error) {
+		getErr := m.get(ctx, types.NamespacedName{Name: m.webhookName}, webhookConf)
+		if apierrors.IsNotFound(getErr) {
+			m.log.V(1).Info("Webhook configuration not found yet")
+			return false, nil
+		}
+		return getErr == nil, getErr
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed waiting for %s webhook %s", m.webhookType, m.webhookName)
+	}
+	m.log.Info("Webhook configuration found")
+	return nil
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package certificate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// RestartedAtAnnotationKey is the pod template annotation set by
+	// `kubectl rollout restart`
+	RestartedAtAnnotationKey = "kubectl.kubernetes.io/restartedAt"
+
+	// SecretHashAnnotationKey is the pod template annotation where
+	// PropagateSecretHash keeps the TLS secret hash
+	SecretHashAnnotationKey = "kube-admission-webhook.kubevirt.io/secret-hash"
+)
+
+// WorkloadKind is the kind of the workload running the webhook server
+type WorkloadKind string
+
+const (
+	DeploymentWorkload  WorkloadKind = "Deployment"
+	DaemonSetWorkload   WorkloadKind = "DaemonSet"
+	StatefulSetWorkload WorkloadKind = "StatefulSet"
+)
+
+// Workload references the Deployment, DaemonSet or StatefulSet running the
+// webhook server
+type Workload struct {
+	Kind      WorkloadKind
+	Namespace string
+	Name      string
+}
+
+func (w Workload) String() string {
+	return fmt.Sprintf("%s/%s/%s", w.Kind, w.Namespace, w.Name)
+}
+
+func (w Workload) validate() error {
+	if w.Kind != DeploymentWorkload && w.Kind != DaemonSetWorkload && w.Kind != StatefulSetWorkload {
+		return fmt.Errorf("workload kind has to be %s, %s or %s", DeploymentWorkload, DaemonSetWorkload, StatefulSetWorkload)
+	}
+	if w.Namespace == "" || w.Name == "" {
+		return fmt.Errorf("workload namespace and name are required")
+	}
+	return nil
+}
+
+// newWorkloadObject returns an empty object of the workload kind and its
+// pod template
+func newWorkloadObject(kind WorkloadKind) (client.Object, *corev1.PodTemplateSpec, error) {
+	switch kind {
+	case DeploymentWorkload:
+		deployment := &appsv1.Deployment{}
+		return deployment, &deployment.Spec.Template, nil
+	case DaemonSetWorkload:
+		daemonSet := &appsv1.DaemonSet{}
+		return daemonSet, &daemonSet.Spec.Template, nil
+	case StatefulSetWorkload:
+		statefulSet := &appsv1.StatefulSet{}
+		return statefulSet, &statefulSet.Spec.Template, nil
+	}
+	return nil, nil, errors.Errorf("unknown workload kind %s", kind)
+}
+
+// PatchPodTemplateAnnotation sets the key annotation at the workload pod
+// template, changing it rolls out the workload pods.
+func PatchPodTemplateAnnotation(ctx context.Context, cli client.Client, workload Workload, key, value string) error {
+	object, template, err := newWorkloadObject(workload.Kind)
+	if err != nil {
+		return err
+	}
+	err = cli.Get(ctx, types.NamespacedName{Namespace: workload.Namespace, Name: workload.Name}, object)
+	if err != nil {
+		return errors.Wrapf(err, "failed reading workload %s", workload)
+	}
+	if template.Annotations[key] == value {
+		return nil
+	}
+	patch := client.MergeFrom(object.DeepCopyObject().(client.Object))
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[key] = value
+	err = cli.Patch(ctx, object, patch)
+	if err != nil {
+		return errors.Wrapf(err, "failed patching workload %s pod template", workload)
+	}
+	return nil
+}
+
+// SecretHash returns a stable hash of the secret data, it only changes if
+// the certificates, keys or CABundle at the secret change.
+func SecretHash(secret *corev1.Secret) string {
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write(secret.Data[key])
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// PropagateSecretHash patches the hash of the TLS secret at the workload pod
+// template SecretHashAnnotationKey annotation, so workloads mounting the
+// certificates as files roll out when they are rotated, it can be called
+// from the operator reconciling the workload.
+func PropagateSecretHash(ctx context.Context, cli client.Client, secretKey types.NamespacedName, workload Workload) error {
+	secret := corev1.Secret{}
+	err := cli.Get(ctx, secretKey, &secret)
+	if err != nil {
+		return errors.Wrapf(err, "failed reading TLS secret %s", secretKey)
+	}
+	return PatchPodTemplateAnnotation(ctx, cli, workload, SecretHashAnnotationKey, SecretHash(&secret))
+}
+
+// restartWorkloads rolls out Options.RestartWorkloads after the services
+// certificates change so webhook servers reading them only at startup
+// serve the new ones, failures are logged since the rotation is already
+// done.
+func (m *Manager) restartWorkloads(ctx context.Context) {
+	restartedAt := m.now().UTC().Format(time.RFC3339)
+	for _, workload := range m.workloadsToRestart {
+		m.log.Info("Restarting webhook workload after rotation", "workload", workload.String())
+		err := PatchPodTemplateAnnotation(ctx, m.client, workload, RestartedAtAnnotationKey, restartedAt)
+		if err != nil {
+			m.log.Info(fmt.Sprintf("Failed restarting webhook workload: %v", err))
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package e2e
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/onsi/ginkgo/reporters"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+var (
+	testEnv *envtest.Environment
+	cfg     *rest.Config
+	cli     client.Client
+
+	sideEffects = admissionregistrationv1.SideEffectClassNone
+
+	namespace = corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "e2ewebhook",
+		},
+	}
+
+	service = corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace.Name,
+			Name:      "e2ewebhook-service",
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Name: "https",
+					Port: 443,
+				},
+			},
+		},
+	}
+
+	webhookConfiguration = admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "e2ewebhook",
+		},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{
+				SideEffects:             &sideEffects,
+				AdmissionReviewVersions: []string{"v1"},
+				Name:                    "e2ewebhook.qinqon.io",
+				ClientConfig: admissionregistrationv1.WebhookClientConfig{
+					Service: &admissionregistrationv1.ServiceReference{
+						Name:      service.Name,
+						Namespace: service.Namespace,
+					},
+				},
+			},
+		},
+	}
+)
+
+var _ = BeforeSuite(func() {
+	testEnv = &envtest.Environment{}
+
+	var err error
+	cfg, err = testEnv.Start()
+	Expect(err).ToNot(HaveOccurred(), "should success starting testenv")
+
+	cli, err = client.New(cfg, client.Options{})
+	Expect(err).ToNot(HaveOccurred(), "should success creating client")
+
+	By("Create namespace, webhook configuration and service")
+	err = cli.Create(context.TODO(), namespace.DeepCopy())
+	Expect(err).ToNot(HaveOccurred(), "should success creating namespace")
+
+	err = cli.Create(context.TODO(), webhookConfiguration.DeepCopy())
+	Expect(err).ToNot(HaveOccurred(), "should success creating mutatingwebhookconfiguration")
+
+	err = cli.Create(context.TODO(), service.DeepCopy())
+	Expect(err).ToNot(HaveOccurred(), "should success creating service")
+})
+
+var _ = AfterSuite(func() {
+	err := testEnv.Stop()
+	Expect(err).ToNot(HaveOccurred(), "should success stopping testenv")
+})
+
+func TestE2E(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("junit.e2e_suite_test.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "E2E Test Suite", []Reporter{junitReporter})
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright 2022 Kube Admission Webhook Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at:
+ *
+ *	  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/qinqon/kube-admission-webhook/pkg/certificate"
+)
+
+const (
+	caRotateInterval    = 1 * time.Minute
+	caOverlapInterval   = 30 * time.Second
+	certRotateInterval  = 30 * time.Second
+	certOverlapInterval = 15 * time.Second
+
+	// propagationDelay simulates the kubelet delay mounting the updated
+	// TLS secret at the webhook pods
+	propagationDelay = 5 * time.Second
+
+	rotationTestDuration = 3 * time.Minute
+	requestInterval      = 100 * time.Millisecond
+)
+
+// webhookServer serves the TLS secret certificate as it's mounted at the
+// webhook pods, refreshing it every propagationDelay
+type webhookServer struct {
+	*httptest.Server
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+}
+
+func startWebhookServer(ctx context.Context) *webhookServer {
+	server := &webhookServer{}
+	server.Server = httptest.NewUnstartedServer(certificate.SelfTestHandler())
+	server.Server.TLS = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: server.getCertificate,
+	}
+	server.StartTLS()
+	go wait.UntilWithContext(ctx, server.loadCertificate, propagationDelay)
+	return server
+}
+
+func (s *webhookServer) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.certificate == nil {
+		return nil, fmt.Errorf("TLS secret not mounted yet")
+	}
+	return s.certificate, nil
+}
+
+func (s *webhookServer) loadCertificate(ctx context.Context) {
+	secret := corev1.Secret{}
+	err := cli.Get(ctx, types.NamespacedName{Namespace: service.Namespace, Name: service.Name}, &secret)
+	if err != nil {
+		return
+	}
+	keyPair, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return
+	}
+	s.mutex.Lock()
+	s.certificate = &keyPair
+	s.mutex.Unlock()
+}
+
+// admissionStats accumulates the results of the admission requests
+type admissionStats struct {
+	requests  int
+	failures  []string
+	caBundles map[string]struct{}
+}
+
+// sendAdmissionRequest sends an AdmissionReview to the webhook server the
+// way the kube-apiserver does, trusting only the webhook configuration
+// CABundle and verifying the service DNS name
+func sendAdmissionRequest(ctx context.Context, serverURL string, caBundle []byte) error {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caBundle) {
+		return fmt.Errorf("failed parsing CABundle")
+	}
+	httpClient := http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			TLSClientConfig: &tls.Config{
+				MinVersion: tls.VersionTLS12,
+				RootCAs:    roots,
+				ServerName: fmt.Sprintf("%s.%s.svc", service.Name, service.Namespace),
+			},
+		},
+	}
+
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: admissionv1.SchemeGroupVersion.String(), Kind: "AdmissionReview"},
+		Request:  &admissionv1.AdmissionRequest{UID: "e2e"},
+	}
+	body, err := json.Marshal(review)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, serverURL+certificate.SelfTestPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	err = json.NewDecoder(response.Body).Decode(&review)
+	if err != nil {
+		return err
+	}
+	if review.Response == nil || !review.Response.Allowed {
+		return fmt.Errorf("admission request not allowed")
+	}
+	return nil
+}
+
+func getCABundle(ctx context.Context) ([]byte, error) {
+	obtainedWebhookConfiguration := admissionregistrationv1.MutatingWebhookConfiguration{}
+	err := cli.Get(ctx, types.NamespacedName{Name: webhookConfiguration.Name}, &obtainedWebhookConfiguration)
+	if err != nil {
+		return nil, err
+	}
+	return obtainedWebhookConfiguration.Webhooks[0].ClientConfig.CABundle, nil
+}
+
+// fireAdmissionRequests sends admission requests every requestInterval
+// until duration elapses
+func fireAdmissionRequests(ctx context.Context, serverURL string, duration time.Duration) admissionStats {
+	stats := admissionStats{caBundles: map[string]struct{}{}}
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		caBundle, err := getCABundle(ctx)
+		if err != nil {
+			return
+		}
+		stats.caBundles[string(caBundle)] = struct{}{}
+		stats.requests++
+		err = sendAdmissionRequest(ctx, serverURL, caBundle)
+		if err != nil && ctx.Err() == nil {
+			stats.failures = append(stats.failures, fmt.Sprintf("%s: %v", time.Now().Format(time.RFC3339), err))
+		}
+	}, requestInterval)
+	return stats
+}
+
+var _ = Describe("Certificates rotation", func() {
+	It("should not fail any admission request while rotating CA and certificates", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		By("Starting the cert manager with short rotation intervals")
+		certManager, err := certificate.NewManager(cli, &certificate.Options{
+			WebhookName:         webhookConfiguration.Name,
+			WebhookType:         certificate.MutatingWebhook,
+			Namespace:           namespace.Name,
+			CARotateInterval:    caRotateInterval,
+			CAOverlapInterval:   caOverlapInterval,
+			CertRotateInterval:  certRotateInterval,
+			CertOverlapInterval: certOverlapInterval,
+		})
+		Expect(err).To(Succeed(), "should succeed constructing certificate manager")
+
+		crManager, err := manager.New(cfg, manager.Options{MetricsBindAddress: "0"})
+		Expect(err).To(Succeed(), "should success creating controller-runtime manager")
+		Expect(certManager.Add(crManager)).To(Succeed(), "should succeed adding the cert manager")
+		go func() {
+			defer GinkgoRecover()
+			Expect(crManager.Start(ctx)).To(Succeed(), "should success starting manager")
+		}()
+
+		By("Starting the webhook server")
+		server := startWebhookServer(ctx)
+		defer server.Close()
+
+		Eventually(func() error {
+			caBundle, err := getCABundle(ctx)
+			if err != nil {
+				return err
+			}
+			return sendAdmissionRequest(ctx, server.URL, caBundle)
+		}, 30*time.Second, time.Second).Should(Succeed(), "should eventually serve admission requests")
+
+		By(fmt.Sprintf("Firing admission requests for %s while rotating", rotationTestDuration))
+		stats := fireAdmissionRequests(ctx, server.URL, rotationTestDuration)
+		Expect(stats.requests).ToNot(BeZero(), "should send admission requests")
+		Expect(len(stats.caBundles)).To(BeNumerically(">", 2), "should rotate the CA during the test")
+		Expect(stats.failures).To(BeEmpty(), "should not fail any admission request")
+	})
+})